@@ -1,32 +1,111 @@
 package operation
 
-import "os"
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+)
 
 type ConfigInterface interface {
 	Load(file string) (*Config, error)
 }
 
 type UploadInterface interface {
-	Upload(file string, key string, overview bool) error
-	UploadBytes(data []byte, key string, overView bool) (err error)
+	Upload(file string, key string, overView bool, byteMode bool) (err error)
+	UploadWithContext(ctx context.Context, file string, key string, overView bool, byteMode bool) (err error)
+	UploadBytes(data []byte, key string, overView bool, byteMode bool) (err error)
+	UploadBytesWithContext(ctx context.Context, data []byte, key string, overView bool, byteMode bool) (err error)
+	UploadFromReader(reader io.Reader, size int64, key string, overView bool, byteMode bool, lastbyte io.Reader) (err error)
+	UploadFromReaderWithContext(ctx context.Context, reader io.Reader, size int64, key string, overView bool, byteMode bool, lastbyte io.Reader) (err error)
+	UploadFromReaderNoByte(reader io.Reader, size int64, key string, overView bool) (err error)
+	UploadFromReaderNoByteWithContext(ctx context.Context, reader io.Reader, size int64, key string, overView bool) (err error)
+	UploadFloder(data []byte, key string, overView bool) (err error)
+	UploadFloderWithContext(ctx context.Context, data []byte, key string, overView bool) (err error)
 }
 
 type DownloadInterface interface {
 	DownloadFile(key string, path string) (f *os.File, err error)
+	DownloadFileWithContext(ctx context.Context, key string, path string) (f *os.File, err error)
+	DownloadFileParallel(key, path string) (*os.File, error)
+	DownloadFileParallelWithContext(ctx context.Context, key, path string) (*os.File, error)
 	DownloadBytes(key string) (data []byte, err error)
+	DownloadBytesWithContext(ctx context.Context, key string) (data []byte, err error)
 	DownloadRangeBytes(key string, offset, size int64) (l int64, data []byte, err error)
+	DownloadRangeBytesWithContext(ctx context.Context, key string, offset, size int64) (l int64, data []byte, err error)
+	DownloadRangeReader(key string, offset, size int64) (l int64, reader io.ReadCloser, err error)
+	DownloadRangeReaderWithContext(ctx context.Context, key string, offset, size int64) (l int64, reader io.ReadCloser, err error)
+	DownloadRaw(key string, headers http.Header) (resp *http.Response, err error)
+	DownloadRawWithContext(ctx context.Context, key string, headers http.Header) (resp *http.Response, err error)
 	GetFileExiet(fileName string) (bool, error)
+	GetFileExietWithContext(ctx context.Context, fileName string) (bool, error)
+	GetFileSize(fileName string) (int64, error)
+	GetFileSizeWithContext(ctx context.Context, fileName string) (int64, error)
 }
 
 type ModifyInterface interface {
 	DeleteFile(key string) (err error)
+	DeleteFileWithContext(ctx context.Context, key string) (err error)
 	RenameFile(key, newname string) (err error)
+	RenameFileWithContext(ctx context.Context, key, newname string) (err error)
+	MetaInfo(key string) (metaInfo *MetaInfo, err error)
+	MetaInfoWithContext(ctx context.Context, key string) (metaInfo *MetaInfo, err error)
+	ListObject(prefix string, size int) (bstFiles *BstFiles, err error)
+	ListObjectWithContext(ctx context.Context, prefix string, size int) (bstFiles *BstFiles, err error)
+	Objects(opts *ListOptions) *ObjectIterator
+	ObjectsWithContext(ctx context.Context, opts *ListOptions) *ObjectIterator
+	BatchDelete(keys []string) ([]BatchResult, error)
+	BatchDeleteWithContext(ctx context.Context, keys []string) ([]BatchResult, error)
+	BatchRename(renames []Rename) ([]BatchResult, error)
+	BatchRenameWithContext(ctx context.Context, renames []Rename) ([]BatchResult, error)
+	BatchMetaInfo(keys []string) ([]MetaResult, error)
+	BatchMetaInfoWithContext(ctx context.Context, keys []string) ([]MetaResult, error)
+	DeletePrefix(prefix string) ([]BatchResult, error)
+	DeletePrefixWithContext(ctx context.Context, prefix string) ([]BatchResult, error)
+	CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error
+	CopyObjectWithContext(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error
+	MoveObject(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error
+	MoveObjectWithContext(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error
+	LinkGen(name string, protocol string) string
+	PresignURL(key string, opts *PresignOptions) (string, error)
+	PresignURLWithContext(ctx context.Context, key string, opts *PresignOptions) (string, error)
 }
 
-type BucketInterface interface {
+// BucketAdminInterface is BucketInterface without ListObject and Objects,
+// whose signatures clash with the ones of ModifyInterface.
+type BucketAdminInterface interface {
 	MakeBucket(bucketName string) (err error)
+	MakeBucketWithContext(ctx context.Context, bucketName string) (err error)
 	DeleteBucket(bucketName string) (err error)
+	DeleteBucketWithContext(ctx context.Context, bucketName string) (err error)
 	ListBucket() (ListBucketReq, error)
+	ListBucketWithContext(ctx context.Context) (ListBucketReq, error)
 	GetBucketInfo(bucketName string) (string, error)
-	ListObject(bucketName, prefix, size, page string) (ListObjectReq, error)
+	GetBucketInfoWithContext(ctx context.Context, bucketName string) (string, error)
+}
+
+type BucketInterface interface {
+	BucketAdminInterface
+	ListObject(bucketName, prefix, size, page string) (*ListObjectReq, error)
+	ListObjectWithContext(ctx context.Context, bucketName, prefix, size, page string) (*ListObjectReq, error)
+	Objects(bucketName string, opts *ListOptions) *ObjectIterator
+	ObjectsWithContext(ctx context.Context, bucketName string, opts *ListOptions) *ObjectIterator
+}
+
+// ClientInterface is implemented by Client, depend on it to swap the client
+// for a mock in tests.
+type ClientInterface interface {
+	UploadInterface
+	DownloadInterface
+	ModifyInterface
+	BucketAdminInterface
+	SetRetryPolicy(r *RetryPolicy)
+	SetBalancer(b Balancer)
+	SetLogger(l Ilog)
+	SetProgressListener(l ProgressListener)
+	SetCredentials(provider CredentialsProvider)
+	Use(interceptors ...Interceptor)
+	SetUploadRateLimit(bytesPerSec int64)
+	SetDownloadRateLimit(bytesPerSec int64)
+	Close() error
 }
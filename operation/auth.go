@@ -0,0 +1,285 @@
+package operation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests are signed with an HMAC-SHA256 of the secret key over
+//
+//	BST-HMAC-SHA256
+//	<X-Bst-Date>
+//	<method>
+//	<escaped path>
+//	<query, sorted by key>
+//	<name>:<value> of every signed header, sorted by name, one per line
+//
+// and carry it as
+//
+//	Authorization: BST-HMAC-SHA256 Credential=<ak>, SignedHeaders=<a;b>, Signature=<hex>
+//
+// The signed headers are host, X-Bst-Date and every header set on the request
+// except those a proxy or the transport may change.
+const (
+	signAlgorithm       = "BST-HMAC-SHA256"
+	signDateHeader      = "X-Bst-Date"
+	signTokenHeader     = "X-Bst-Security-Token"
+	signDateFormat      = "20060102T150405Z"
+	credentialsAkEnv    = "BST_ACCESS_KEY"
+	credentialsSkEnv    = "BST_SECRET_KEY"
+	credentialsTokenEnv = "BST_SESSION_TOKEN"
+)
+
+var unsignedHeaders = map[string]bool{
+	"authorization":   true,
+	"accept-encoding": true,
+	"connection":      true,
+	"content-length":  true,
+	"expect":          true,
+	"user-agent":      true,
+}
+
+// Credentials sign requests. SessionToken is sent along for temporary
+// credentials, Expires is zero for credentials that do not expire.
+type Credentials struct {
+	AccessKey    string    `json:"ak"`
+	SecretKey    string    `json:"sk"`
+	SessionToken string    `json:"token"`
+	Expires      time.Time `json:"expires"`
+}
+
+func (c Credentials) expired(margin time.Duration) bool {
+	return !c.Expires.IsZero() && time.Now().Add(margin).After(c.Expires)
+}
+
+// CredentialsProvider is asked for the credentials of every request, it
+// must be cheap and safe for concurrent use.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsFunc adapts a function to CredentialsProvider.
+type CredentialsFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialsFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+func StaticCredentials(ak, sk string) CredentialsProvider {
+	creds := Credentials{AccessKey: ak, SecretKey: sk}
+	return CredentialsFunc(func(context.Context) (Credentials, error) {
+		return creds, nil
+	})
+}
+
+// EnvCredentials reads BST_ACCESS_KEY, BST_SECRET_KEY and BST_SESSION_TOKEN
+// on every request.
+func EnvCredentials() CredentialsProvider {
+	return CredentialsFunc(func(context.Context) (Credentials, error) {
+		creds := Credentials{
+			AccessKey:    os.Getenv(credentialsAkEnv),
+			SecretKey:    os.Getenv(credentialsSkEnv),
+			SessionToken: os.Getenv(credentialsTokenEnv),
+		}
+		if creds.AccessKey == "" || creds.SecretKey == "" {
+			return Credentials{}, fmt.Errorf("%s or %s not set", credentialsAkEnv, credentialsSkEnv)
+		}
+		return creds, nil
+	})
+}
+
+// FileCredentials reads {"ak": ..., "sk": ..., "token": ..., "expires": ...}
+// from path, the file is read again when it changes, so rotated keys are
+// picked up without a restart.
+func FileCredentials(path string) CredentialsProvider {
+	p := &fileCredentials{path: path}
+	return p
+}
+
+type fileCredentials struct {
+	path string
+
+	m     sync.Mutex
+	mtime time.Time
+	creds Credentials
+}
+
+func (p *fileCredentials) Retrieve(context.Context) (Credentials, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	if info.ModTime().Equal(p.mtime) {
+		return p.creds, nil
+	}
+	b, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var creds Credentials
+	if err = json.Unmarshal(b, &creds); err != nil {
+		return Credentials{}, fmt.Errorf("credentials file %s: %w", p.path, err)
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return Credentials{}, fmt.Errorf("credentials file %s: missing ak or sk", p.path)
+	}
+	p.creds, p.mtime = creds, info.ModTime()
+	return creds, nil
+}
+
+// RefreshableCredentials caches what fetch returns until margin before it
+// expires, then fetches again. Concurrent requests wait for one fetch.
+func RefreshableCredentials(fetch func(ctx context.Context) (Credentials, error), margin time.Duration) CredentialsProvider {
+	return &refreshableCredentials{fetch: fetch, margin: margin}
+}
+
+type refreshableCredentials struct {
+	fetch  func(ctx context.Context) (Credentials, error)
+	margin time.Duration
+
+	m     sync.Mutex
+	creds *Credentials
+}
+
+func (p *refreshableCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.creds != nil && !p.creds.expired(p.margin) {
+		return *p.creds, nil
+	}
+	creds, err := p.fetch(ctx)
+	if err != nil {
+		if p.creds != nil && !p.creds.expired(0) {
+			elog.Warn("credentials refresh failed, using the current ones", err)
+			return *p.creds, nil
+		}
+		return Credentials{}, err
+	}
+	p.creds = &creds
+	return creds, nil
+}
+
+// credentialsFromConfig is the provider set up by a Config: its keys, the
+// credentials file, or the environment, nil when none of them is set.
+func credentialsFromConfig(c *Config) CredentialsProvider {
+	switch {
+	case c.AccessKey != "":
+		return StaticCredentials(c.AccessKey, c.SecretKey)
+	case c.CredentialsFile != "":
+		return FileCredentials(c.CredentialsFile)
+	case os.Getenv(credentialsAkEnv) != "":
+		return EnvCredentials()
+	}
+	return nil
+}
+
+// Signer signs the requests of the clients it is shared by.
+type Signer struct {
+	provider CredentialsProvider
+	now      func() time.Time
+}
+
+// NewSigner signs with the credentials of provider, nil leaves requests
+// unsigned.
+func NewSigner(provider CredentialsProvider) *Signer {
+	return &Signer{provider: provider, now: time.Now}
+}
+
+// Sign sets the date and Authorization headers of req, it is called again
+// for every attempt so the date stays fresh.
+func (s *Signer) Sign(req *http.Request) error {
+	if s == nil || s.provider == nil {
+		return nil
+	}
+	creds, err := s.credentials(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set(signDateHeader, s.now().UTC().Format(signDateFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set(signTokenHeader, creds.SessionToken)
+	} else {
+		req.Header.Del(signTokenHeader)
+	}
+	signed, toSign := stringToSign(req)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, creds.AccessKey, strings.Join(signed, ";"), signature(creds.SecretKey, toSign)))
+	return nil
+}
+
+func (s *Signer) credentials(ctx context.Context) (Credentials, error) {
+	if s == nil || s.provider == nil {
+		return Credentials{}, errors.New("credentials: none configured")
+	}
+	creds, err := s.provider.Retrieve(ctx)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("credentials: %w", err)
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return Credentials{}, errors.New("credentials: empty access or secret key")
+	}
+	return creds, nil
+}
+
+func stringToSign(req *http.Request) (signed []string, toSign string) {
+	signed = []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if !unsignedHeaders[name] {
+			signed = append(signed, name)
+		}
+	}
+	sort.Strings(signed)
+
+	var b strings.Builder
+	b.WriteString(signAlgorithm + "\n")
+	b.WriteString(req.Header.Get(signDateHeader) + "\n")
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.URL.EscapedPath() + "\n")
+	b.WriteString(req.URL.Query().Encode() + "\n")
+	for _, name := range signed {
+		value := req.Host
+		if name != "host" {
+			value = strings.Join(req.Header.Values(name), ",")
+		} else if value == "" {
+			value = req.URL.Host
+		}
+		b.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	return signed, b.String()
+}
+
+func signature(secretKey, toSign string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(toSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetCredentials signs the requests of this client with provider, nil stops
+// signing.
+func (b *clientBase) SetCredentials(provider CredentialsProvider) {
+	signer := NewSigner(provider)
+	b.settings.Lock()
+	defer b.settings.Unlock()
+	b.signer = signer
+}
+
+func (b *clientBase) currentSigner() *Signer {
+	b.settings.RLock()
+	defer b.settings.RUnlock()
+	return b.signer
+}
@@ -0,0 +1,167 @@
+package operation_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func writeCredentials(t *testing.T, file, json string) {
+	t.Helper()
+	if err := ioutil.WriteFile(file, []byte(json), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setenv sets key for the test and restores it afterwards.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// Every kind of request is signed, a server that checks signatures accepts
+// the right keys and nothing else.
+func TestSigning(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(t *testing.T, c *operation.Config)
+		ok     bool
+	}{
+		{"config keys", func(t *testing.T, c *operation.Config) {
+			c.AccessKey, c.SecretKey = "ak", "sk"
+		}, true},
+		{"wrong secret", func(t *testing.T, c *operation.Config) {
+			c.AccessKey, c.SecretKey = "ak", "other"
+		}, false},
+		{"unknown access key", func(t *testing.T, c *operation.Config) {
+			c.AccessKey, c.SecretKey = "other", "sk"
+		}, false},
+		{"credentials file", func(t *testing.T, c *operation.Config) {
+			c.CredentialsFile = filepath.Join(t.TempDir(), "credentials.json")
+			writeCredentials(t, c.CredentialsFile, `{"ak": "ak", "sk": "sk"}`)
+		}, true},
+		{"environment", func(t *testing.T, c *operation.Config) {
+			setenv(t, "BST_ACCESS_KEY", "ak")
+			setenv(t, "BST_SECRET_KEY", "sk")
+		}, true},
+		{"unsigned", func(t *testing.T, c *operation.Config) {}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.SetCredentials("ak", "sk")
+			cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1, PartSize: 4096, UpConcurrency: 2}
+			tt.config(t, cfg)
+			c, err := operation.NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			file, _ := writeTempFile(t, "obj", 10000)
+			calls := []func() error{
+				func() error { return c.Upload(file, "multipart", true, false) },
+				func() error { return c.UploadBytes([]byte("data"), "obj", true, false) },
+				func() error { _, _, err := c.DownloadRangeBytes("obj", 1, 2); return err },
+				func() error { _, err := c.MetaInfo("obj"); return err },
+				func() error { _, err := c.ListObject("", 10); return err },
+				func() error { return c.RenameFile("obj", "renamed") },
+				func() error { return c.DeleteFile("renamed") },
+			}
+			for i, call := range calls {
+				err := call()
+				if tt.ok && err != nil {
+					t.Fatalf("call %d: %v", i, err)
+				}
+				if !tt.ok && !errors.Is(err, operation.ErrAccessDenied) {
+					t.Fatalf("call %d: err = %v, want access denied", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileCredentialsRotation(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetCredentials("ak", "sk")
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	writeCredentials(t, file, `{"ak": "ak", "sk": "sk"}`)
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1, CredentialsFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = c.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetCredentials("ak2", "sk2")
+	writeCredentials(t, file, `{"ak": "ak2", "sk": "sk2"}`)
+	// the file is reread when its modification time changes
+	later := time.Now().Add(time.Second)
+	if err = os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.DownloadBytes("obj"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshableCredentials(t *testing.T) {
+	var (
+		m       sync.Mutex
+		fetches int
+	)
+	provider := operation.RefreshableCredentials(func(ctx context.Context) (operation.Credentials, error) {
+		m.Lock()
+		defer m.Unlock()
+		fetches++
+		return operation.Credentials{AccessKey: "ak", SecretKey: "sk", Expires: time.Now().Add(50 * time.Millisecond)}, nil
+	}, 10*time.Millisecond)
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetCredentials("ak", "sk")
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetCredentials(provider)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.ListBucket(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Fatalf("%d fetches for credentials that did not expire", fetches)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err = c.ListBucket(); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Errorf("%d fetches, expired credentials not refreshed", fetches)
+	}
+}
@@ -0,0 +1,281 @@
+package operation
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balancer picks the host for the next request and learns from the outcome
+// of every request sent to it. Implementations must be safe for concurrent
+// use.
+type Balancer interface {
+	Pick(hosts []string) string
+	Succeed(host string, latency time.Duration)
+	Fail(host string)
+}
+
+const (
+	BalancerRoundRobin = "round_robin"
+	BalancerLatency    = "latency"
+)
+
+func newBalancer(c *Config) Balancer {
+	if c.Balancer == BalancerLatency {
+		return NewLatencyBalancer()
+	}
+	return NewRoundRobinBalancer()
+}
+
+type roundRobinBalancer struct {
+	index uint32
+}
+
+// NewRoundRobinBalancer walks the hosts in turn and skips the ones that
+// failed MaxContinuousFailureTimes within MaxContinuousFailureDuration.
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{}
+}
+
+func (b *roundRobinBalancer) Pick(hosts []string) string {
+	switch len(hosts) {
+	case 0:
+		panic("No Io hosts is configured")
+	case 1:
+		return hosts[0]
+	default:
+		var host string
+		for i := 0; i <= len(hosts)*MaxFindHostsPrecent/100; i++ {
+			index := int(atomic.AddUint32(&b.index, 1) - 1)
+			host = hosts[index%len(hosts)]
+			if isHostNameValid(host) {
+				break
+			}
+		}
+		return host
+	}
+}
+
+func (b *roundRobinBalancer) Succeed(host string, latency time.Duration) {
+	succeedHostName(host)
+}
+
+func (b *roundRobinBalancer) Fail(host string) {
+	failHostName(host)
+}
+
+// LatencyBalancer keeps an exponentially weighted moving average of the
+// latency and error rate of every host and picks the better of two random
+// hosts. A host that failed MaxContinuousFailureTimes in a row is left out
+// until a health check or MaxContinuousFailureDuration lets it back in, and
+// then only receives a growing share of requests during SlowStart.
+type LatencyBalancer struct {
+	Alpha     float64
+	SlowStart time.Duration
+
+	m     sync.Mutex
+	stats map[string]*hostStats
+}
+
+type hostStats struct {
+	latency     float64
+	errRate     float64
+	samples     int
+	failures    int
+	lastFail    time.Time
+	recoveredAt time.Time
+}
+
+func NewLatencyBalancer() *LatencyBalancer {
+	return &LatencyBalancer{
+		Alpha:     0.3,
+		SlowStart: 30 * time.Second,
+		stats:     make(map[string]*hostStats),
+	}
+}
+
+func (b *LatencyBalancer) getStats(host string) *hostStats {
+	s, ok := b.stats[host]
+	if !ok {
+		s = &hostStats{}
+		b.stats[host] = s
+	}
+	return s
+}
+
+func (b *LatencyBalancer) isDown(s *hostStats, now time.Time) bool {
+	if s.failures < MaxContinuousFailureTimes {
+		return false
+	}
+	if s.lastFail.Add(MaxContinuousFailureDuration).Before(now) {
+		b.recover(s, now)
+		return false
+	}
+	return true
+}
+
+func (b *LatencyBalancer) recover(s *hostStats, now time.Time) {
+	s.failures = 0
+	s.recoveredAt = now
+}
+
+func (b *LatencyBalancer) score(s *hostStats, now time.Time) float64 {
+	score := (s.latency + float64(time.Millisecond)) * (1 + 4*s.errRate)
+	if !s.recoveredAt.IsZero() && b.SlowStart > 0 {
+		warm := float64(now.Sub(s.recoveredAt)) / float64(b.SlowStart)
+		if warm < 1 {
+			if warm < 0.1 {
+				warm = 0.1
+			}
+			score /= warm
+		}
+	}
+	return score
+}
+
+func (b *LatencyBalancer) Pick(hosts []string) string {
+	switch len(hosts) {
+	case 0:
+		panic("No Io hosts is configured")
+	case 1:
+		return hosts[0]
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	now := time.Now()
+	alive := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if !b.isDown(b.getStats(host), now) {
+			alive = append(alive, host)
+		}
+	}
+	if len(alive) == 0 {
+		// every host is down, try the one that failed longest ago
+		best := hosts[0]
+		for _, host := range hosts[1:] {
+			if b.stats[host].lastFail.Before(b.stats[best].lastFail) {
+				best = host
+			}
+		}
+		return best
+	}
+	if len(alive) == 1 {
+		return alive[0]
+	}
+
+	randomLock.Lock()
+	i := random.Intn(len(alive))
+	j := random.Intn(len(alive) - 1)
+	randomLock.Unlock()
+	if j >= i {
+		j++
+	}
+	if b.score(b.stats[alive[j]], now) < b.score(b.stats[alive[i]], now) {
+		return alive[j]
+	}
+	return alive[i]
+}
+
+func (b *LatencyBalancer) Succeed(host string, latency time.Duration) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	s := b.getStats(host)
+	if s.samples == 0 {
+		s.latency = float64(latency)
+	} else {
+		s.latency += b.Alpha * (float64(latency) - s.latency)
+	}
+	s.errRate -= b.Alpha * s.errRate
+	s.samples++
+	if s.failures >= MaxContinuousFailureTimes {
+		b.recover(s, time.Now())
+	}
+	s.failures = 0
+}
+
+func (b *LatencyBalancer) Fail(host string) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	s := b.getStats(host)
+	s.errRate += b.Alpha * (1 - s.errRate)
+	s.failures++
+	s.lastFail = time.Now()
+}
+
+// HealthChecker probes hosts in the background and reports the results to
+// a Balancer, so hosts that went down are found before requests hit them and
+// recovered hosts are let back in.
+type HealthChecker struct {
+	balancer Balancer
+	hosts    func() []string
+	interval time.Duration
+	probe    func(ctx context.Context, host string) error
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewHealthChecker checks the hosts returned by hosts every interval, probe
+// may be nil to use defaultProbe.
+func NewHealthChecker(balancer Balancer, hosts func() []string, interval time.Duration,
+	probe func(ctx context.Context, host string) error) *HealthChecker {
+
+	if probe == nil {
+		probe = defaultProbe
+	}
+	return &HealthChecker{
+		balancer: balancer,
+		hosts:    hosts,
+		interval: interval,
+		probe:    probe,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (hc *HealthChecker) Start() {
+	go func() {
+		t := time.NewTicker(hc.interval)
+		defer t.Stop()
+		for {
+			hc.checkAll()
+			select {
+			case <-t.C:
+			case <-hc.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (hc *HealthChecker) Stop() {
+	hc.once.Do(func() {
+		close(hc.stop)
+	})
+}
+
+func (hc *HealthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, host := range hc.hosts() {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), hc.interval)
+			defer cancel()
+			start := time.Now()
+			if err := hc.probe(ctx, host); err != nil {
+				elog.Debug("health check failed", host, err)
+				hc.balancer.Fail(host)
+				return
+			}
+			hc.balancer.Succeed(host, time.Now().Sub(start))
+		}(host)
+	}
+	wg.Wait()
+}
+
+// defaultProbe treats any answer below 500 as healthy, the path does not
+// need to exist.
+var defaultProbe = probeWith(SchemeHTTP, &http.Client{Timeout: defaultQueryTimeout})
@@ -0,0 +1,182 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	defaultBatchConcurrency = 8
+	deletePrefixChunk       = 1000
+)
+
+// BatchResult is the outcome of one key of a batch, results come in the
+// order of the keys.
+type BatchResult struct {
+	Key string
+	Err error
+}
+
+// MetaResult is BatchResult for BatchMetaInfo.
+type MetaResult struct {
+	Key  string
+	Meta *MetaInfo
+	Err  error
+}
+
+// Rename is one rename of BatchRename.
+type Rename struct {
+	Key     string
+	NewName string
+}
+
+// runBatch calls do for 0 to count-1 on n goroutines. The calls not started
+// when ctx is done get the error of ctx.
+func runBatch(ctx context.Context, n, count int, do func(i int) error) []error {
+	errs := make([]error, count)
+	if n > count {
+		n = count
+	}
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				errs[i] = do(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+	return errs
+}
+
+// batchError sums up the failures of a batch, the first one is wrapped.
+func batchError(keys []string, errs []error) error {
+	failed, first := 0, -1
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d keys failed, first %s: %w", failed, len(keys), keys[first], errs[first])
+}
+
+func (d *Modify) concurrency() int {
+	if d.batchConcurrency > 0 {
+		return d.batchConcurrency
+	}
+	return defaultBatchConcurrency
+}
+
+func (d *Modify) BatchDelete(keys []string) ([]BatchResult, error) {
+	return d.BatchDeleteWithContext(context.Background(), keys)
+}
+
+// BatchDeleteWithContext deletes keys BatchConcurrency at a time, each with
+// the retries of DeleteFile. The error sums up the keys that failed.
+func (d *Modify) BatchDeleteWithContext(ctx context.Context, keys []string) ([]BatchResult, error) {
+	errs := runBatch(ctx, d.concurrency(), len(keys), func(i int) error {
+		return d.DeleteFileWithContext(ctx, keys[i])
+	})
+	results := make([]BatchResult, len(keys))
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key, Err: errs[i]}
+	}
+	return results, batchError(keys, errs)
+}
+
+func (d *Modify) BatchRename(renames []Rename) ([]BatchResult, error) {
+	return d.BatchRenameWithContext(context.Background(), renames)
+}
+
+// BatchRenameWithContext renames BatchConcurrency keys at a time. Renames
+// run in no particular order, chains like a to b and b to c need separate
+// batches.
+func (d *Modify) BatchRenameWithContext(ctx context.Context, renames []Rename) ([]BatchResult, error) {
+	keys := make([]string, len(renames))
+	for i, r := range renames {
+		keys[i] = r.Key
+	}
+	errs := runBatch(ctx, d.concurrency(), len(renames), func(i int) error {
+		return d.RenameFileWithContext(ctx, renames[i].Key, renames[i].NewName)
+	})
+	results := make([]BatchResult, len(renames))
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key, Err: errs[i]}
+	}
+	return results, batchError(keys, errs)
+}
+
+func (d *Modify) BatchMetaInfo(keys []string) ([]MetaResult, error) {
+	return d.BatchMetaInfoWithContext(context.Background(), keys)
+}
+
+// BatchMetaInfoWithContext looks up keys BatchConcurrency at a time, missing
+// keys have an Err that matches ErrNotFound.
+func (d *Modify) BatchMetaInfoWithContext(ctx context.Context, keys []string) ([]MetaResult, error) {
+	results := make([]MetaResult, len(keys))
+	errs := runBatch(ctx, d.concurrency(), len(keys), func(i int) (err error) {
+		results[i].Meta, err = d.MetaInfoWithContext(ctx, keys[i])
+		return
+	})
+	for i, key := range keys {
+		results[i].Key, results[i].Err = key, errs[i]
+	}
+	return results, batchError(keys, errs)
+}
+
+func (d *Modify) DeletePrefix(prefix string) ([]BatchResult, error) {
+	return d.DeletePrefixWithContext(context.Background(), prefix)
+}
+
+// DeletePrefixWithContext deletes every object whose key starts with prefix,
+// floder markers included. Keys are listed and deleted a chunk at a time, the
+// listing of the next chunk starts after the last key of the previous one so
+// that the deletes do not shift it. A listing that fails stops the delete,
+// the error wraps it and counts the keys that failed before.
+func (d *Modify) DeletePrefixWithContext(ctx context.Context, prefix string) ([]BatchResult, error) {
+	var results []BatchResult
+	var keys []string
+	var errs []error
+	after := ""
+	for {
+		it := d.ObjectsWithContext(ctx, &ListOptions{Prefix: prefix, StartAfter: after, PageSize: deletePrefixChunk})
+		chunk := make([]string, 0, deletePrefixChunk)
+		for len(chunk) < deletePrefixChunk && it.Next() {
+			chunk = append(chunk, it.Entry().Name)
+		}
+		if err := it.Err(); err != nil {
+			if berr := batchError(keys, errs); berr != nil {
+				return results, fmt.Errorf("%v, then listing failed: %w", berr, err)
+			}
+			return results, err
+		}
+		if len(chunk) == 0 {
+			return results, batchError(keys, errs)
+		}
+		after = chunk[len(chunk)-1]
+		chunkResults, _ := d.BatchDeleteWithContext(ctx, chunk)
+		for _, r := range chunkResults {
+			results = append(results, r)
+			keys = append(keys, r.Key)
+			errs = append(errs, r.Err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package operation_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// batchSeed holds the keys the batches work on, each with itself as content.
+var batchSeed = map[string]string{"a": "a", "b": "b", "c": "c", "p/1": "p/1", "p/2": "p/2", "p/3/x": "p/3/x", "q": "q"}
+
+// newBatchConfig sets the batch concurrency and stops retries.
+func newBatchConfig(concurrency int) func(c *operation.Config) {
+	return func(c *operation.Config) {
+		c.Retry = 1
+		c.BatchConcurrency = concurrency
+	}
+}
+
+// Every batch reports one result per key in the order of the keys, the
+// error counts the failed ones and matches the first failure.
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		run   func(c *operation.Client) ([]string, []error, error)
+		fails []bool
+		left  []string
+	}{
+		{"delete", func(c *operation.Client) ([]string, []error, error) {
+			res, err := c.BatchDelete([]string{"a", "missing", "c"})
+			keys, errs := batchResults(res)
+			return keys, errs, err
+		}, []bool{false, true, false}, []string{"b", "p/1", "p/2", "p/3/x", "q"}},
+		{"rename", func(c *operation.Client) ([]string, []error, error) {
+			res, err := c.BatchRename([]operation.Rename{{Key: "a", NewName: "a2"}, {Key: "b", NewName: "q"}, {Key: "c", NewName: "c2"}})
+			keys, errs := batchResults(res)
+			return keys, errs, err
+		}, []bool{false, true, false}, []string{"a2", "b", "c2", "p/1", "p/2", "p/3/x", "q"}},
+		{"meta info", func(c *operation.Client) ([]string, []error, error) {
+			res, err := c.BatchMetaInfo([]string{"a", "b", "missing"})
+			var keys []string
+			var errs []error
+			for _, r := range res {
+				if r.Err == nil && r.Meta.Size != int64(len(r.Key)) {
+					r.Err = errors.New("wrong size")
+				}
+				keys, errs = append(keys, r.Key), append(errs, r.Err)
+			}
+			return keys, errs, err
+		}, []bool{false, false, true}, []string{"a", "b", "c", "p/1", "p/2", "p/3/x", "q"}},
+		{"delete prefix", func(c *operation.Client) ([]string, []error, error) {
+			res, err := c.DeletePrefix("p/")
+			keys, errs := batchResults(res)
+			return keys, errs, err
+		}, []bool{false, false, false}, []string{"a", "b", "c", "q"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := newTestClient(t, batchSeed, newBatchConfig(2))
+			keys, errs, err := tt.run(c)
+			if len(errs) != len(tt.fails) {
+				t.Fatalf("%d results for %d keys", len(errs), len(tt.fails))
+			}
+			failed := false
+			for i, e := range errs {
+				if (e != nil) != tt.fails[i] {
+					t.Errorf("%s: %v", keys[i], e)
+				}
+				failed = failed || e != nil
+			}
+			if failed != (err != nil) {
+				t.Errorf("err = %v", err)
+			}
+			if got := remoteKeys(t, c, ""); !reflect.DeepEqual(got, tt.left) {
+				t.Errorf("left %v, want %v", got, tt.left)
+			}
+		})
+	}
+}
+
+func batchResults(res []operation.BatchResult) (keys []string, errs []error) {
+	for _, r := range res {
+		keys, errs = append(keys, r.Key), append(errs, r.Err)
+	}
+	return
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	s, _ := newTestClient(t, batchSeed, newBatchConfig(3))
+	s.SetFault(&bsttest.Fault{Latency: 20 * time.Millisecond})
+	var (
+		m             sync.Mutex
+		running, peak int
+	)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		m.Unlock()
+		defer func() {
+			m.Lock()
+			running--
+			m.Unlock()
+		}()
+		s.ServeHTTP(w, r)
+	}))
+	defer front.Close()
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{strings.TrimPrefix(front.URL, "http://")}, Bucket: "bucket", BatchConcurrency: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.BatchMetaInfo([]string{"a", "b", "c", "p/1", "p/2", "p/3/x", "q"}); err != nil {
+		t.Fatal(err)
+	}
+	if peak != 3 {
+		t.Errorf("%d requests at once, want 3", peak)
+	}
+}
+
+func TestBatchCanceled(t *testing.T) {
+	s, c := newTestClient(t, batchSeed, newBatchConfig(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := c.BatchDeleteWithContext(ctx, []string{"a", "b", "c"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v", err)
+	}
+	for _, r := range res {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("%s: %v", r.Key, r.Err)
+		}
+	}
+	if n := s.Count("deletefile"); n != 0 {
+		t.Errorf("%d deletes sent", n)
+	}
+}
+
+// A listing that fails after some deletes failed reports both.
+func TestDeletePrefixListFailure(t *testing.T) {
+	s, _ := newTestClient(t, batchSeed, nil)
+	var lists int32
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/objects/listobject/") && atomic.AddInt32(&lists, 1) > 1:
+			http.Error(w, "listing broke", http.StatusBadRequest)
+		case strings.HasPrefix(r.URL.Path, "/objects/deletefile/") && strings.HasSuffix(r.URL.Path, "/p/2"):
+			http.Error(w, "delete broke", http.StatusBadRequest)
+		default:
+			s.ServeHTTP(w, r)
+		}
+	}))
+	defer front.Close()
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{strings.TrimPrefix(front.URL, "http://")}, Bucket: "bucket", Retry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	res, err := c.DeletePrefix("p/")
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"1 of 3 keys failed", "p/2", "delete broke", "listing broke"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	var e *operation.Error
+	if !errors.As(err, &e) || e.Op != operation.OpListObject {
+		t.Errorf("err does not wrap the listing error: %v", err)
+	}
+	if len(res) != 3 {
+		t.Errorf("%d results, want 3", len(res))
+	}
+}
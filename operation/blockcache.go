@@ -0,0 +1,373 @@
+package operation
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBlockSize = 1 << 20
+	defaultReadAhead = 2
+)
+
+// BlockCache keeps blocks of objects read through DownloadRangeBytes and
+// Object.ReadAt. Blocks are aligned to BlockSize and held in an LRU in
+// memory, blocks evicted from memory go to an optional LRU on disk. Reads of
+// the same block at the same time share one request, and reads that walk an
+// object block after block fetch the next ReadAhead blocks in the
+// background. Objects overwritten on the server may be served stale until
+// their blocks expire or are evicted, set a TTL when that matters.
+type BlockCache struct {
+	blockSize int64
+	readAhead int
+	ttl       time.Duration
+
+	m      sync.Mutex
+	mem    *blockLru
+	disk   *blockLru
+	dir    string
+	calls  map[string]*blockCall
+	recent map[string]int64
+}
+
+type cachedBlock struct {
+	data  []byte
+	total int64
+	at    time.Time
+}
+
+type blockCall struct {
+	done  chan struct{}
+	block *cachedBlock
+	err   error
+}
+
+// NewBlockCache builds the cache described by c, it returns nil when
+// BlockCacheSize is not set.
+func NewBlockCache(c *Config) *BlockCache {
+	if c.BlockCacheSize <= 0 {
+		return nil
+	}
+	bc := &BlockCache{
+		blockSize: c.BlockSize,
+		readAhead: c.ReadAhead,
+		ttl:       time.Duration(c.BlockCacheTtlMs) * time.Millisecond,
+		mem:       newBlockLru(c.BlockCacheSize),
+		calls:     make(map[string]*blockCall),
+		recent:    make(map[string]int64),
+	}
+	if bc.blockSize <= 0 {
+		bc.blockSize = defaultBlockSize
+	}
+	if bc.readAhead == 0 {
+		bc.readAhead = defaultReadAhead
+	}
+	if c.BlockCacheDir != "" && c.BlockCacheDiskSize > 0 {
+		if err := os.MkdirAll(c.BlockCacheDir, 0755); err != nil {
+			elog.Warn("block cache dir unusable, disk tier disabled", c.BlockCacheDir, err)
+		} else {
+			bc.dir = c.BlockCacheDir
+			bc.disk = newBlockLru(c.BlockCacheDiskSize)
+		}
+	}
+	return bc
+}
+
+// SetBlockCache makes ranged reads go through bc, several downloaders may
+// share one cache. nil disables caching.
+func (d *Downloader) SetBlockCache(bc *BlockCache) {
+	d.cache = bc
+}
+
+func blockKey(bucket, key string, index int64) string {
+	return fmt.Sprintf("%s/%s#%d", bucket, key, index)
+}
+
+// readRange serves [offset, offset+size) from cached blocks, fetching the
+// missing ones. It returns the total length of the object like
+// DownloadRangeBytes.
+func (d *Downloader) readRange(ctx context.Context, key string, offset, size int64) (int64, []byte, error) {
+	bc := d.cache
+	bs := bc.blockSize
+	first, last := offset/bs, (offset+size-1)/bs
+	buf := make([]byte, 0, size)
+	var total int64
+	for index := first; index <= last; index++ {
+		block, err := bc.get(ctx, d, key, index)
+		if err != nil {
+			return -1, nil, err
+		}
+		total = block.total
+		start := offset - index*bs
+		if start < 0 {
+			start = 0
+		}
+		end := offset + size - index*bs
+		if end > int64(len(block.data)) {
+			end = int64(len(block.data))
+		}
+		if start < end {
+			buf = append(buf, block.data[start:end]...)
+		}
+		if (index+1)*bs >= total {
+			last = index
+			break
+		}
+	}
+	bc.prefetch(d, key, first, last, total)
+	return total, buf, nil
+}
+
+// get returns block index of key from the cache or fetches it, reads of a
+// block being fetched wait for that fetch. A waiter whose own context is
+// still alive fetches the block itself when the fetch it waited for was
+// canceled.
+func (bc *BlockCache) get(ctx context.Context, d *Downloader, key string, index int64) (*cachedBlock, error) {
+	k := blockKey(d.bucket, key, index)
+	for {
+		if block := bc.lookup(k); block != nil {
+			return block, nil
+		}
+		bc.m.Lock()
+		call, ok := bc.calls[k]
+		if !ok {
+			break
+		}
+		bc.m.Unlock()
+		select {
+		case <-call.done:
+			if call.err != nil && isContextError(call.err) && ctx.Err() == nil {
+				continue
+			}
+			return call.block, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &blockCall{done: make(chan struct{})}
+	bc.calls[k] = call
+	bc.m.Unlock()
+
+	call.block, call.err = d.fetchBlock(ctx, key, index*bc.blockSize, bc.blockSize)
+
+	var evicted []*lruEntry
+	bc.m.Lock()
+	delete(bc.calls, k)
+	if call.err == nil {
+		evicted = bc.mem.add(k, call.block)
+	}
+	bc.m.Unlock()
+	close(call.done)
+	bc.spill(evicted)
+	return call.block, call.err
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// prefetch fetches the blocks after last in the background when this read
+// started right after the previous read of the same object ended.
+func (bc *BlockCache) prefetch(d *Downloader, key string, first, last, total int64) {
+	objKey := d.bucket + "/" + key
+	bc.m.Lock()
+	prev, ok := bc.recent[objKey]
+	bc.recent[objKey] = last
+	if len(bc.recent) > 1024 {
+		bc.recent = map[string]int64{objKey: last}
+	}
+	bc.m.Unlock()
+	if !ok || (first != prev+1 && first != prev) || bc.readAhead < 0 {
+		return
+	}
+	for i := int64(1); i <= int64(bc.readAhead); i++ {
+		index := last + i
+		if index*bc.blockSize >= total {
+			break
+		}
+		go func(index int64) {
+			if _, err := bc.get(context.Background(), d, key, index); err != nil {
+				d.logger().Debug("read ahead failed", key, index, err)
+			}
+		}(index)
+	}
+}
+
+// fetchBlock downloads up to size bytes at offset, the block is short at the
+// end of the object. Like downloadRangeTo it tries every host before
+// failing.
+func (d *Downloader) fetchBlock(ctx context.Context, key string, offset, size int64) (block *cachedBlock, err error) {
+	failedIoHosts := make(map[string]struct{})
+	err = d.retryPolicy().failover(ctx, d.logger(), OpDownload, len(d.hosts(ctx)), func() error {
+		host := d.nextHostExcept(ctx, failedIoHosts)
+		response, err := d.getRange(ctx, host, key, offset, size)
+		if err != nil {
+			failedIoHosts[host] = struct{}{}
+			return err
+		}
+		defer response.Body.Close()
+		total, err := getTotalLength(response.Header.Get("Content-Range"))
+		if err != nil {
+			failedIoHosts[host] = struct{}{}
+			return err
+		}
+		if offset >= total {
+			return &Error{Op: OpDownload, StatusCode: http.StatusRequestedRangeNotSatisfiable, Host: host, Bucket: d.bucket, Key: key,
+				Message: fmt.Sprintf("offset %d beyond the %d bytes of the object", offset, total), Kind: ErrRangeNotSatisfiable}
+		}
+		n := size
+		if offset+n > total {
+			n = total - offset
+		}
+		data := make([]byte, n)
+		body := progressReader(ctx, d.limitReader(ctx, host, response.Body), int(offset/size)+1, 0)
+		if _, err = io.ReadFull(body, data); err != nil {
+			failedIoHosts[host] = struct{}{}
+			d.failHost(host)
+			return err
+		}
+		block = &cachedBlock{data: data, total: total, at: time.Now()}
+		return nil
+	})
+	return
+}
+
+// lookup returns the block k when it is cached and fresh, a block found on
+// disk is read back into memory. The files are read and written without
+// holding bc.m.
+func (bc *BlockCache) lookup(k string) *cachedBlock {
+	bc.m.Lock()
+	if block := bc.mem.get(k); block != nil {
+		if bc.fresh(block) {
+			bc.m.Unlock()
+			return block
+		}
+		bc.mem.remove(k)
+	}
+	var meta *cachedBlock
+	if bc.disk != nil {
+		if meta = bc.disk.get(k); meta != nil {
+			bc.disk.remove(k)
+		}
+	}
+	bc.m.Unlock()
+	if meta == nil {
+		return nil
+	}
+	path := bc.diskPath(k)
+	data, err := ioutil.ReadFile(path)
+	os.Remove(path)
+	if err != nil || !bc.fresh(meta) {
+		return nil
+	}
+	block := &cachedBlock{data: data, total: meta.total, at: meta.at}
+	bc.m.Lock()
+	evicted := bc.mem.add(k, block)
+	bc.m.Unlock()
+	bc.spill(evicted)
+	return block
+}
+
+func (bc *BlockCache) fresh(block *cachedBlock) bool {
+	return bc.ttl <= 0 || time.Now().Sub(block.at) < bc.ttl
+}
+
+// spill moves blocks evicted from memory to disk, it must be called without
+// bc.m held. A block only enters the disk lru once its file is written.
+func (bc *BlockCache) spill(evicted []*lruEntry) {
+	if bc.disk == nil {
+		return
+	}
+	for _, e := range evicted {
+		if !bc.fresh(e.block) {
+			continue
+		}
+		if err := ioutil.WriteFile(bc.diskPath(e.key), e.block.data, 0644); err != nil {
+			elog.Debug("block cache write failed", e.key, err)
+			continue
+		}
+		// the disk lru only keeps the metadata, the data lives in the file
+		meta := &cachedBlock{total: e.block.total, at: e.block.at}
+		bc.m.Lock()
+		old := bc.disk.addSized(e.key, meta, int64(len(e.block.data)))
+		bc.m.Unlock()
+		for _, o := range old {
+			os.Remove(bc.diskPath(o.key))
+		}
+	}
+}
+
+func (bc *BlockCache) diskPath(k string) string {
+	sum := sha1.Sum([]byte(k))
+	return filepath.Join(bc.dir, hex.EncodeToString(sum[:])+".blk")
+}
+
+// blockLru evicts the least recently used blocks once their sizes add up to
+// more than capacity.
+type blockLru struct {
+	capacity int64
+	used     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	block *cachedBlock
+	size  int64
+}
+
+func newBlockLru(capacity int64) *blockLru {
+	return &blockLru{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (l *blockLru) get(k string) *cachedBlock {
+	e, ok := l.items[k]
+	if !ok {
+		return nil
+	}
+	l.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).block
+}
+
+func (l *blockLru) add(k string, block *cachedBlock) []*lruEntry {
+	return l.addSized(k, block, int64(len(block.data)))
+}
+
+func (l *blockLru) addSized(k string, block *cachedBlock, size int64) (evicted []*lruEntry) {
+	l.remove(k)
+	l.items[k] = l.ll.PushFront(&lruEntry{key: k, block: block, size: size})
+	l.used += size
+	for l.used > l.capacity && l.ll.Len() > 1 {
+		e := l.ll.Back()
+		entry := e.Value.(*lruEntry)
+		l.ll.Remove(e)
+		delete(l.items, entry.key)
+		l.used -= entry.size
+		evicted = append(evicted, entry)
+	}
+	return
+}
+
+func (l *blockLru) remove(k string) {
+	if e, ok := l.items[k]; ok {
+		l.ll.Remove(e)
+		delete(l.items, k)
+		l.used -= e.Value.(*lruEntry).size
+	}
+}
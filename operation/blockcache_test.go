@@ -0,0 +1,161 @@
+package operation_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+const testBlockSize = 4096
+
+// newCachedDownloader serves a four block object through a downloader with
+// a block cache configured by config.
+func newCachedDownloader(t *testing.T, config func(c *operation.Config)) (*bsttest.Server, *operation.Downloader, []byte) {
+	t.Helper()
+	s := bsttest.NewServer("bucket")
+	t.Cleanup(s.Close)
+	data := randomBytes(4 * testBlockSize)
+	s.PutObject("bucket", "obj", data)
+	cfg := &operation.Config{
+		IoHosts:          []string{s.Host()},
+		Bucket:           "bucket",
+		RetryBaseDelayMs: 1,
+		BlockCacheSize:   1 << 20,
+		BlockSize:        testBlockSize,
+		ReadAhead:        -1,
+	}
+	if config != nil {
+		config(cfg)
+	}
+	d := operation.NewDownloader(cfg)
+	t.Cleanup(func() { d.Close() })
+	return s, d, data
+}
+
+func TestBlockCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  func(c *operation.Config)
+		blocks  []int64
+		pause   time.Duration
+		fetches int
+	}{
+		{"cached", nil, []int64{0, 0, 0}, 0, 1},
+		{"memory only evicts", func(c *operation.Config) {
+			c.BlockCacheSize = testBlockSize
+		}, []int64{0, 1, 0}, 0, 3},
+		{"disk tier", func(c *operation.Config) {
+			c.BlockCacheSize = testBlockSize
+			c.BlockCacheDir = t.TempDir()
+			c.BlockCacheDiskSize = 1 << 20
+		}, []int64{0, 1, 0, 1, 2, 0}, 0, 3},
+		{"expired", func(c *operation.Config) {
+			c.BlockCacheTtlMs = 20
+		}, []int64{0, 0}, 40 * time.Millisecond, 2},
+		{"read ahead", func(c *operation.Config) {
+			c.ReadAhead = 2
+		}, []int64{0, 1}, 50 * time.Millisecond, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, d, data := newCachedDownloader(t, tt.config)
+			for _, index := range tt.blocks {
+				offset := index * testBlockSize
+				total, got, err := d.DownloadRangeBytes("obj", offset, testBlockSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if total != int64(len(data)) || !bytes.Equal(got, data[offset:offset+testBlockSize]) {
+					t.Fatalf("block %d: %d bytes of %d", index, len(got), total)
+				}
+				time.Sleep(tt.pause)
+			}
+			if n := s.Count("getfile"); n != tt.fetches {
+				t.Errorf("%d fetches, want %d", n, tt.fetches)
+			}
+		})
+	}
+}
+
+func TestBlockCacheSharesFetches(t *testing.T) {
+	s, d, data := newCachedDownloader(t, nil)
+	s.SetFault(&bsttest.Fault{Latency: 50 * time.Millisecond})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, got, err := d.DownloadRangeBytes("obj", 100, 1000)
+			if err != nil || !bytes.Equal(got, data[100:1100]) {
+				t.Errorf("%d bytes, %v", len(got), err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.Count("getfile"); n != 1 {
+		t.Errorf("%d fetches of one block", n)
+	}
+}
+
+// A read waiting on the fetch of a caller that gave up fetches the block
+// itself.
+func TestBlockCacheWaiterOutlivesLeader(t *testing.T) {
+	s, d, data := newCachedDownloader(t, nil)
+	s.SetFault(&bsttest.Fault{Latency: 50 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	leader := make(chan error)
+	go func() {
+		_, _, err := d.DownloadRangeBytesWithContext(ctx, "obj", 0, 1000)
+		leader <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	_, got, err := d.DownloadRangeBytes("obj", 0, 1000)
+	if err != nil || !bytes.Equal(got, data[:1000]) {
+		t.Fatalf("%d bytes, %v", len(got), err)
+	}
+	if err = <-leader; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("leader: %v", err)
+	}
+}
+
+// shrunkObject answers every range as if the object had 10 bytes.
+type shrunkObject struct {
+	http.ResponseWriter
+}
+
+func (w shrunkObject) WriteHeader(statusCode int) {
+	if statusCode == http.StatusPartialContent {
+		w.Header().Set("Content-Range", "bytes 0-9/10")
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// A block past the end of an object that shrank between two reads is an
+// error, not a negative length.
+func TestBlockCacheOffsetBeyondObject(t *testing.T) {
+	var s *bsttest.Server
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(shrunkObject{w}, r)
+	}))
+	defer front.Close()
+	s, d, _ := newCachedDownloader(t, func(c *operation.Config) {
+		c.IoHosts = []string{strings.TrimPrefix(front.URL, "http://")}
+	})
+	_, _, err := d.DownloadRangeBytes("obj", testBlockSize, 100)
+	if !errors.Is(err, operation.ErrRangeNotSatisfiable) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := s.Count("getfile"); n != 1 {
+		t.Errorf("%d fetches", n)
+	}
+}
@@ -0,0 +1,110 @@
+package bsttest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+)
+
+// maxClockSkew is how far the X-Bst-Date of a signed request may be off.
+const maxClockSkew = 15 * time.Minute
+
+// SetCredentials makes the host require requests signed with ak and sk,
+// others are answered with 403. An empty ak accepts unsigned requests again.
+func (s *Server) SetCredentials(ak, sk string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.ak, s.sk = ak, sk
+}
+
+// authorize answers 403 unless r is signed or made with a presigned url, for
+// the latter it serves the signed range and content disposition.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, ak, sk string) bool {
+	if r.URL.Query().Get("X-Bst-Signature") == "" {
+		ok, msg := authorized(r, ak, sk)
+		if !ok {
+			http.Error(w, msg, http.StatusForbidden)
+		}
+		return ok
+	}
+	p, err := operation.VerifyPresigned(r, func(accessKey string) (string, error) {
+		if accessKey != ak {
+			return "", errors.New("unknown access key")
+		}
+		return sk, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	if r.Header.Get("Range") == "" && p.Range() != "" {
+		r.Header.Set("Range", p.Range())
+	}
+	if p.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", p.ContentDisposition)
+	}
+	return true
+}
+
+// authorized checks the signature of r the way the real server does, it is
+// written apart from the signer of the client on purpose.
+func authorized(r *http.Request, ak, sk string) (bool, string) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "BST-HMAC-SHA256 ") {
+		return false, "missing signature"
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "BST-HMAC-SHA256 "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	if fields["Credential"] != ak {
+		return false, "unknown access key"
+	}
+	date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Bst-Date"))
+	if err != nil {
+		return false, "bad date"
+	}
+	if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return false, "request expired"
+	}
+
+	headers := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(headers) {
+		return false, "signed headers not sorted"
+	}
+	lines := []string{
+		"BST-HMAC-SHA256",
+		r.Header.Get("X-Bst-Date"),
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+	}
+	signedDate := false
+	for _, name := range headers {
+		value := r.Host
+		if name != "host" {
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		signedDate = signedDate || name == "x-bst-date"
+		lines = append(lines, name+":"+strings.TrimSpace(value))
+	}
+	if !signedDate {
+		return false, "date not signed"
+	}
+	mac := hmac.New(sha256.New, []byte(sk))
+	mac.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(fields["Signature"])) {
+		return false, "signature mismatch"
+	}
+	return true, ""
+}
@@ -0,0 +1,39 @@
+package bsttest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// verifyChecksum checks data against a checksum header value of the form
+// "<algorithm>:<hex digest>", an empty value is accepted.
+func verifyChecksum(value string, data []byte) error {
+	if value == "" {
+		return nil
+	}
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return fmt.Errorf("invalid checksum %q", value)
+	}
+	var h hash.Hash
+	switch value[:i] {
+	case "crc32c":
+		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "md5":
+		h = md5.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return fmt.Errorf("unsupported checksum %q", value)
+	}
+	h.Write(data)
+	if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, value[i+1:]) {
+		return fmt.Errorf("checksum mismatch, expected %s got %s", value[i+1:], actual)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package bsttest
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Fault describes the misbehaviour of a host. Rates are fractions between 0
+// and 1 of the matching requests.
+type Fault struct {
+	// Latency delays every matching request.
+	Latency time.Duration
+	// ErrorRate of the requests are answered with StatusCode, 503 if unset.
+	ErrorRate  float64
+	StatusCode int
+	// DropRate of the requests have their connection closed without an
+	// answer.
+	DropRate float64
+	// DropAfter closes the connection after that many bytes of the response
+	// body, to simulate a transfer broken in the middle.
+	DropAfter int64
+	// Match limits the fault to some requests, nil matches all of them.
+	Match func(r *http.Request) bool
+}
+
+var (
+	randomLock sync.Mutex
+	random     = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	randomLock.Lock()
+	defer randomLock.Unlock()
+	return random.Float64() < rate
+}
+
+// SetFault makes the host misbehave as described by f, nil heals it.
+func (s *Server) SetFault(f *Fault) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.fault = f
+}
+
+func (f *Fault) match(r *http.Request) bool {
+	return f.Match == nil || f.Match(r)
+}
+
+// inject applies the fault and reports whether the request was answered.
+func (f *Fault) inject(w http.ResponseWriter) bool {
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	if chance(f.DropRate) {
+		panic(http.ErrAbortHandler)
+	}
+	if chance(f.ErrorRate) {
+		code := f.StatusCode
+		if code == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		http.Error(w, "injected fault", code)
+		return true
+	}
+	return false
+}
+
+type truncatingWriter struct {
+	http.ResponseWriter
+	left int64
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) >= w.left {
+		w.ResponseWriter.Write(p[:w.left])
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		panic(http.ErrAbortHandler)
+	}
+	w.left -= int64(len(p))
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,718 @@
+// Package bsttest provides an in-memory BST server for tests, it speaks the
+// /objects/* protocol used by the operation package, including the server
+// side copy, the multipart upload endpoints and the uc /v4/query endpoint.
+// SetCredentials turns on the checking of request signatures and presigned
+// urls.
+//
+// A Cluster runs several hosts over one shared store, faults are injected
+// per host so failover can be tested offline:
+//
+//	c := bsttest.NewCluster(3, "bucket")
+//	defer c.Close()
+//	c[0].SetFault(&bsttest.Fault{ErrorRate: 1})
+//	cfg := &operation.Config{IoHosts: c.Hosts(), Bucket: "bucket"}
+package bsttest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data   []byte
+	header http.Header
+	mtime  time.Time
+}
+
+type bucket struct {
+	created time.Time
+	objects map[string]*object
+}
+
+type multipartUpload struct {
+	bucket string
+	key    string
+	parts  map[int][]byte
+}
+
+// store is the state shared by the hosts of a cluster.
+type store struct {
+	m       sync.Mutex
+	buckets map[string]*bucket
+	uploads map[string]*multipartUpload
+	nextId  int64
+}
+
+func newStore() *store {
+	return &store{
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]*multipartUpload),
+	}
+}
+
+// Server is one fake io host, it also answers uc queries with the hosts of
+// its cluster.
+type Server struct {
+	*httptest.Server
+	store   *store
+	cluster func() []string
+
+	m      sync.Mutex
+	fault  *Fault
+	counts map[string]int
+	ak, sk string
+}
+
+// NewServer starts a single host with the given buckets already created.
+func NewServer(buckets ...string) *Server {
+	s := newServer(newStore())
+	s.cluster = func() []string { return []string{s.Host()} }
+	for _, name := range buckets {
+		s.MakeBucket(name)
+	}
+	return s
+}
+
+func newServer(st *store) *Server {
+	s := &Server{
+		store:  st,
+		counts: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Host returns host:port, the form used in Config.IoHosts.
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Count returns how many requests the host received for action, the path
+// element after /objects/, or "query" for uc queries.
+func (s *Server) Count(action string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.counts[action]
+}
+
+// MakeBucket creates a bucket directly in the store.
+func (s *Server) MakeBucket(name string) {
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	if _, ok := s.store.buckets[name]; !ok {
+		s.store.buckets[name] = &bucket{created: time.Now(), objects: make(map[string]*object)}
+	}
+}
+
+// PutObject stores an object directly, creating the bucket if needed.
+func (s *Server) PutObject(bucketName, key string, data []byte) {
+	s.MakeBucket(bucketName)
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	s.store.buckets[bucketName].objects[key] = &object{
+		data:   append([]byte(nil), data...),
+		header: make(http.Header),
+		mtime:  time.Now(),
+	}
+}
+
+// Object returns the content of an object and whether it exists.
+func (s *Server) Object(bucketName, key string) ([]byte, bool) {
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	obj := s.store.getObject(bucketName, key)
+	if obj == nil {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// ObjectHeader returns the request headers stored with an object, they are
+// what metadetail reports as extern-headers.
+func (s *Server) ObjectHeader(bucketName, key string) http.Header {
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	obj := s.store.getObject(bucketName, key)
+	if obj == nil {
+		return nil
+	}
+	return obj.header.Clone()
+}
+
+// CorruptObject flips a bit of a stored object and keeps its checksum, so
+// the next download fails verification. It reports whether the object
+// exists.
+func (s *Server) CorruptObject(bucketName, key string) bool {
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	obj := s.store.getObject(bucketName, key)
+	if obj == nil || len(obj.data) == 0 {
+		return false
+	}
+	data := append([]byte(nil), obj.data...)
+	data[len(data)/2] ^= 1
+	obj.data = data
+	return true
+}
+
+func (st *store) getObject(bucketName, key string) *object {
+	b, ok := st.buckets[bucketName]
+	if !ok {
+		return nil
+	}
+	return b.objects[key]
+}
+
+// Cluster is a set of hosts sharing one store.
+type Cluster []*Server
+
+// NewCluster starts n hosts with the given buckets already created.
+func NewCluster(n int, buckets ...string) Cluster {
+	st := newStore()
+	c := make(Cluster, n)
+	for i := range c {
+		c[i] = newServer(st)
+		c[i].cluster = c.Hosts
+	}
+	for _, name := range buckets {
+		c[0].MakeBucket(name)
+	}
+	return c
+}
+
+// Hosts returns host:port of every host, the form used in Config.IoHosts.
+func (c Cluster) Hosts() []string {
+	hosts := make([]string, len(c))
+	for i, s := range c {
+		hosts[i] = s.Host()
+	}
+	return hosts
+}
+
+func (c Cluster) Close() {
+	for _, s := range c {
+		s.Close()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action, bucketName, key := splitPath(r.URL.Path)
+	if r.URL.Path == "/v4/query" {
+		action = "query"
+	}
+	s.m.Lock()
+	s.counts[action]++
+	fault := s.fault
+	ak, sk := s.ak, s.sk
+	s.m.Unlock()
+
+	if fault != nil && fault.match(r) {
+		if fault.inject(w) {
+			return
+		}
+		if fault.DropAfter > 0 {
+			w = &truncatingWriter{ResponseWriter: w, left: fault.DropAfter}
+		}
+	}
+	if ak != "" && !s.authorize(w, r, ak, sk) {
+		return
+	}
+
+	switch action {
+	case "query":
+		s.query(w, r)
+	case "put":
+		s.put(w, r, bucketName, key)
+	case "getfile":
+		s.getFile(w, r, bucketName, key)
+	case "deletefile":
+		s.deleteFile(w, r, bucketName, key)
+	case "rename":
+		s.rename(w, r, bucketName, key)
+	case "copy":
+		s.copyObject(w, r, bucketName, key)
+	case "metadetail":
+		s.metaDetail(w, r)
+	case "listobject":
+		s.listObject(w, r, bucketName)
+	case "makebucket":
+		s.makeBucket(w, r, bucketName)
+	case "deletebucket":
+		s.deleteBucket(w, r, bucketName)
+	case "listbucket":
+		s.listBucket(w, r)
+	case "getbucket":
+		s.getBucket(w, r, bucketName)
+	case "initmultipart":
+		s.initMultipart(w, r, bucketName, key)
+	case "putpart":
+		s.putPart(w, r, bucketName, key)
+	case "completemultipart":
+		s.completeMultipart(w, r, bucketName, key)
+	case "abortmultipart":
+		s.abortMultipart(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// splitPath splits /objects/{action}/{bucket}/{key}, the key may contain
+// slashes and is taken verbatim.
+func splitPath(path string) (action, bucketName, key string) {
+	if !strings.HasPrefix(path, "/objects/") {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "/objects/"), "/", 3)
+	action = parts[0]
+	if len(parts) > 1 {
+		bucketName = parts[1]
+	}
+	if len(parts) > 2 {
+		key = parts[2]
+	}
+	return
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// storedHeader keeps the request headers the way the real server does, they
+// come back as extern-headers.
+func storedHeader(r *http.Request) (http.Header, error) {
+	h := r.Header.Clone()
+	for _, name := range []string{"Content-Length", "Accept-Encoding", "User-Agent", "Overwrite", "Uploadid", "Partnumber"} {
+		h.Del(name)
+	}
+	if lastbytes := h.Get("lastbytes"); lastbytes != "" {
+		b, err := base64.StdEncoding.DecodeString(lastbytes)
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("invalid lastbytes %q", lastbytes)
+		}
+	}
+	return h, nil
+}
+
+// storeObject must be called with the store locked.
+func (s *Server) storeObject(w http.ResponseWriter, r *http.Request, bucketName, key string, data []byte) bool {
+	b, ok := s.store.buckets[bucketName]
+	if !ok {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+		return false
+	}
+	header, err := storedHeader(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err = verifyChecksum(header.Get("checksum"), data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if _, exists := b.objects[key]; exists && r.Header.Get("overwrite") == "false" {
+		http.Error(w, "obj already exist", http.StatusConflict)
+		return false
+	}
+	b.objects[key] = &object{data: data, header: header, mtime: time.Now()}
+	return true
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "PUT") {
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength >= 0 && int64(len(data)) != r.ContentLength {
+		http.Error(w, "short body", http.StatusBadRequest)
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	s.storeObject(w, r, bucketName, key, data)
+}
+
+func (s *Server) getFile(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "GET", "HEAD") {
+		return
+	}
+	s.store.m.Lock()
+	obj := s.store.getObject(bucketName, key)
+	s.store.m.Unlock()
+	if obj == nil {
+		http.Error(w, "Object Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if checksum := obj.header.Get("checksum"); checksum != "" {
+		w.Header().Set("checksum", checksum)
+	}
+	http.ServeContent(w, r, "", obj.mtime, bytes.NewReader(obj.data))
+}
+
+func (s *Server) deleteFile(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "DELETE") {
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	if s.store.getObject(bucketName, key) == nil {
+		http.Error(w, "Object Not Found", http.StatusNotFound)
+		return
+	}
+	delete(s.store.buckets[bucketName].objects, key)
+}
+
+func (s *Server) rename(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "PUT") {
+		return
+	}
+	newName := r.Header.Get("newname")
+	if newName == "" {
+		http.Error(w, "missing newname", http.StatusBadRequest)
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	obj := s.store.getObject(bucketName, key)
+	if obj == nil {
+		http.Error(w, "Object Not Found", http.StatusNotFound)
+		return
+	}
+	b := s.store.buckets[bucketName]
+	if _, exists := b.objects[newName]; exists {
+		http.Error(w, "obj already exist", http.StatusConflict)
+		return
+	}
+	delete(b.objects, key)
+	b.objects[newName] = obj
+}
+
+// copyObject copies the object named by the srcbucket and object headers to
+// bucketName/key. Tests of the client side fallback disable it with a Fault
+// answering 501 on /objects/copy/.
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "PUT") {
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	src := s.store.getObject(r.Header.Get("srcbucket"), r.Header.Get("object"))
+	if src == nil {
+		http.Error(w, "Object Not Found", http.StatusNotFound)
+		return
+	}
+	b, ok := s.store.buckets[bucketName]
+	if !ok {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+		return
+	}
+	if _, exists := b.objects[key]; exists && r.Header.Get("overwrite") == "false" {
+		http.Error(w, "obj already exist", http.StatusConflict)
+		return
+	}
+	header := src.header.Clone()
+	if header.Get("floder") != "" {
+		header.Set("floder", key)
+	}
+	b.objects[key] = &object{data: append([]byte(nil), src.data...), header: header, mtime: time.Now()}
+}
+
+type metaDetail struct {
+	Name      string      `json:"name"`
+	Size      int64       `json:"size"`
+	Type      int         `json:"type"`
+	Time      int64       `json:"time"`
+	Url       string      `json:"url"`
+	Exheaders http.Header `json:"extern-headers"`
+}
+
+func (s *Server) metaDetail(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+	bucketName, key := r.Header.Get("bucket"), r.Header.Get("object")
+	s.store.m.Lock()
+	obj := s.store.getObject(bucketName, key)
+	s.store.m.Unlock()
+	if obj == nil {
+		http.Error(w, "Object Not Found", http.StatusNotFound)
+		return
+	}
+	writeJson(w, metaDetail{
+		Name:      key,
+		Size:      int64(len(obj.data)),
+		Time:      obj.mtime.Unix(),
+		Url:       s.objectUrl(bucketName, key),
+		Exheaders: obj.header,
+	})
+}
+
+func (s *Server) objectUrl(bucketName, key string) string {
+	return fmt.Sprintf("%s/objects/getfile/%s/%s", s.URL, bucketName, key)
+}
+
+type listEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Type int    `json:"type"`
+	Time int64  `json:"time"`
+	Url  string `json:"url"`
+	Dir  bool   `json:"isDir"`
+}
+
+type listResult struct {
+	Data []listEntry `json:"Data"`
+	Len  int         `json:"Len"`
+}
+
+// listObject returns the objects whose name starts with the Prefix header
+// sorted by name, size objects per page. Page counts from 1 and Len is the
+// number of matching objects over all pages.
+func (s *Server) listObject(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+	prefix := r.Header.Get("Prefix")
+	size, _ := strconv.Atoi(r.Header.Get("size"))
+	page, _ := strconv.Atoi(r.Header.Get("Page"))
+	if size <= 0 {
+		size = 1000
+	}
+	if page <= 0 {
+		page = 1
+	}
+
+	s.store.m.Lock()
+	b, ok := s.store.buckets[bucketName]
+	if !ok {
+		s.store.m.Unlock()
+		http.Error(w, "bucket not found", http.StatusNotFound)
+		return
+	}
+	var names []string
+	for name := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	ret := listResult{Data: []listEntry{}, Len: len(names)}
+	for i := (page - 1) * size; i < len(names) && i < page*size; i++ {
+		obj := b.objects[names[i]]
+		ret.Data = append(ret.Data, listEntry{
+			Name: names[i],
+			Size: int64(len(obj.data)),
+			Time: obj.mtime.Unix(),
+			Url:  s.objectUrl(bucketName, names[i]),
+			Dir:  obj.header.Get("floder") != "",
+		})
+	}
+	s.store.m.Unlock()
+	writeJson(w, ret)
+}
+
+func (s *Server) makeBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if !allowMethod(w, r, "PUT") {
+		return
+	}
+	if bucketName == "" {
+		http.Error(w, "missing bucket", http.StatusBadRequest)
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	if _, ok := s.store.buckets[bucketName]; ok {
+		http.Error(w, "bucket already exist", http.StatusConflict)
+		return
+	}
+	s.store.buckets[bucketName] = &bucket{created: time.Now(), objects: make(map[string]*object)}
+}
+
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if !allowMethod(w, r, "DELETE") {
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	b, ok := s.store.buckets[bucketName]
+	if !ok {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+		return
+	}
+	if len(b.objects) > 0 {
+		http.Error(w, "Bucket not empty cannot delete", http.StatusBadRequest)
+		return
+	}
+	delete(s.store.buckets, bucketName)
+}
+
+type bucketEntry struct {
+	Name      string `json:"Name"`
+	SizeLimit int    `json:"SizeLimit"`
+	Time      int    `json:"Time"`
+}
+
+func (s *Server) listBucket(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+	s.store.m.Lock()
+	ret := []bucketEntry{}
+	for name, b := range s.store.buckets {
+		ret = append(ret, bucketEntry{Name: name, Time: int(b.created.Unix())})
+	}
+	s.store.m.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	writeJson(w, ret)
+}
+
+func (s *Server) getBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if !allowMethod(w, r, "HEAD", "GET") {
+		return
+	}
+	s.store.m.Lock()
+	_, ok := s.store.buckets[bucketName]
+	s.store.m.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Server) initMultipart(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "POST") {
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	if _, ok := s.store.buckets[bucketName]; !ok {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+		return
+	}
+	s.store.nextId++
+	id := strconv.FormatInt(s.store.nextId, 10)
+	s.store.uploads[id] = &multipartUpload{bucket: bucketName, key: key, parts: make(map[int][]byte)}
+	writeJson(w, map[string]string{"uploadId": id})
+}
+
+// getUpload must be called with the store locked.
+func (s *Server) getUpload(w http.ResponseWriter, r *http.Request, bucketName, key string) *multipartUpload {
+	u, ok := s.store.uploads[r.Header.Get("uploadid")]
+	if !ok || u.bucket != bucketName || u.key != key {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return nil
+	}
+	return u
+}
+
+func (s *Server) putPart(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "PUT") {
+		return
+	}
+	partNumber, err := strconv.Atoi(r.Header.Get("partnumber"))
+	if err != nil || partNumber <= 0 {
+		http.Error(w, "invalid partnumber", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	if u := s.getUpload(w, r, bucketName, key); u != nil {
+		u.parts[partNumber] = data
+	}
+}
+
+type completePart struct {
+	PartNumber int   `json:"partNumber"`
+	Size       int64 `json:"size"`
+}
+
+func (s *Server) completeMultipart(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	if !allowMethod(w, r, "POST") {
+		return
+	}
+	var args struct {
+		Parts []completePart `json:"parts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	u := s.getUpload(w, r, bucketName, key)
+	if u == nil {
+		return
+	}
+	var data []byte
+	for i, part := range args.Parts {
+		b, ok := u.parts[part.PartNumber]
+		if part.PartNumber != i+1 || !ok || int64(len(b)) != part.Size {
+			http.Error(w, fmt.Sprintf("invalid part %d", part.PartNumber), http.StatusBadRequest)
+			return
+		}
+		data = append(data, b...)
+	}
+	if s.storeObject(w, r, bucketName, key, data) {
+		delete(s.store.uploads, r.Header.Get("uploadid"))
+	}
+}
+
+func (s *Server) abortMultipart(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "DELETE") {
+		return
+	}
+	s.store.m.Lock()
+	defer s.store.m.Unlock()
+	delete(s.store.uploads, r.Header.Get("uploadid"))
+}
+
+type queryHost struct {
+	Ttl int64 `json:"ttl"`
+	Io  struct {
+		Domains []string `json:"domains"`
+	} `json:"io"`
+}
+
+// query answers uc queries with every host of the cluster.
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+	h := queryHost{Ttl: 300}
+	h.Io.Domains = s.cluster()
+	writeJson(w, map[string][]queryHost{"hosts": {h}})
+}
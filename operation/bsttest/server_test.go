@@ -0,0 +1,149 @@
+package bsttest_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// clientOf builds a client talking to s only.
+func clientOf(t *testing.T, s *bsttest.Server) *operation.Client {
+	t.Helper()
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// The hosts of a cluster serve one store, what is written through one host
+// is seen through the others.
+func TestClusterSharesStore(t *testing.T) {
+	c := bsttest.NewCluster(2, "bucket")
+	defer c.Close()
+	w, r := clientOf(t, c[0]), clientOf(t, c[1])
+
+	tests := []struct {
+		name  string
+		write func() error
+		check func() error
+	}{
+		{"upload", func() error {
+			return w.UploadBytes([]byte("data"), "a/obj", true, false)
+		}, func() error {
+			got, err := r.DownloadBytes("a/obj")
+			if err == nil && !bytes.Equal(got, []byte("data")) {
+				err = errors.New("wrong content " + string(got))
+			}
+			return err
+		}},
+		{"folder", func() error {
+			return w.UploadFloder(nil, "a/dir", true)
+		}, func() error {
+			meta, err := r.MetaInfo("a/dir")
+			if err == nil && !meta.Dir {
+				err = errors.New("not a folder")
+			}
+			return err
+		}},
+		{"rename", func() error {
+			return w.RenameFile("a/obj", "a/renamed")
+		}, func() error {
+			if _, err := r.MetaInfo("a/obj"); !errors.Is(err, operation.ErrNotFound) {
+				return errors.New("old name still there")
+			}
+			_, err := r.MetaInfo("a/renamed")
+			return err
+		}},
+		{"list", func() error {
+			return w.UploadBytes([]byte("data"), "a/other", true, false)
+		}, func() error {
+			files, err := r.ListObject("a/", 2)
+			if err == nil && (files.Len != 3 || len(files.Data) != 2 || files.Data[0].Name != "a/dir") {
+				err = errors.New("wrong listing")
+			}
+			return err
+		}},
+		{"delete", func() error {
+			return w.DeleteFile("a/other")
+		}, func() error {
+			if _, err := r.DownloadBytes("a/other"); !errors.Is(err, operation.ErrNotFound) {
+				return errors.New("still there")
+			}
+			return nil
+		}},
+		{"make bucket", func() error {
+			return w.MakeBucket("other")
+		}, func() error {
+			_, err := r.GetBucketInfo("other")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.write(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.check(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault *bsttest.Fault
+		check func(t *testing.T, err error, elapsed time.Duration)
+	}{
+		{"latency", &bsttest.Fault{Latency: 50 * time.Millisecond}, func(t *testing.T, err error, elapsed time.Duration) {
+			if err != nil || elapsed < 50*time.Millisecond {
+				t.Errorf("err %v after %v", err, elapsed)
+			}
+		}},
+		{"status code", &bsttest.Fault{ErrorRate: 1, StatusCode: http.StatusNotFound}, func(t *testing.T, err error, elapsed time.Duration) {
+			if !errors.Is(err, operation.ErrNotFound) {
+				t.Errorf("err = %v", err)
+			}
+		}},
+		{"dropped connection", &bsttest.Fault{DropRate: 1}, func(t *testing.T, err error, elapsed time.Duration) {
+			if !errors.Is(err, operation.ErrHostUnavailable) {
+				t.Errorf("err = %v", err)
+			}
+		}},
+		{"truncated body", &bsttest.Fault{DropAfter: 10}, func(t *testing.T, err error, elapsed time.Duration) {
+			if err == nil {
+				t.Error("truncated download succeeded")
+			}
+		}},
+		{"other requests", &bsttest.Fault{ErrorRate: 1, Match: func(r *http.Request) bool {
+			return r.Method == "PUT"
+		}}, func(t *testing.T, err error, elapsed time.Duration) {
+			if err != nil {
+				t.Errorf("err = %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", bytes.Repeat([]byte("x"), 1000))
+			s.SetFault(tt.fault)
+			c := clientOf(t, s)
+			start := time.Now()
+			_, err := c.DownloadBytes("obj")
+			tt.check(t, err, time.Since(start))
+			if s.Count("getfile") != 1 {
+				t.Errorf("getfile = %d", s.Count("getfile"))
+			}
+		})
+	}
+}
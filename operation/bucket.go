@@ -1,20 +1,17 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
-	"sync/atomic"
 	"time"
 )
 
 type Bucketer struct {
-	bucket  string
-	ioHosts []string
-	queryer *Queryer
+	bucket string
+	*clientBase
 }
 
 type ListBucketReq []struct {
@@ -45,87 +42,47 @@ type BstFileList struct {
 	Dir  bool   `json:"isDir"`
 }
 
-var bucketClient = &http.Client{
-	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   1 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	},
-	Timeout: 10 * time.Minute,
-}
-
-func (b *Bucketer) nextBucketHost() string {
-	ioHosts := b.ioHosts
-	if b.queryer != nil {
-		if hosts := b.queryer.QueryIoHosts(false); len(hosts) > 0 {
-			shuffleHosts(hosts)
-			ioHosts = hosts
-		}
-	}
-	switch len(ioHosts) {
-	case 0:
-		panic("No Io hosts is configured")
-	case 1:
-		return ioHosts[0]
-	default:
-		var ioHost string
-		for i := 0; i <= len(ioHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curIoHostIndex, 1) - 1)
-			ioHost = ioHosts[index%len(ioHosts)]
-			if isHostNameValid(ioHost) {
-				break
-			}
-		}
-		return ioHost
-	}
-}
-
-func (b *Bucketer) makeBucketInner(bucketName string) error {
-	host := b.nextBucketHost()
+func (b *Bucketer) makeBucketInner(ctx context.Context, bucketName string) error {
+	host := b.nextHost(ctx)
+	start := time.Now()
 	//fmt.Printf("make Bucket %s \n", b.bucket)
-	url := fmt.Sprintf("http://%s/objects/makebucket/%s", host, bucketName)
-	req, err := http.NewRequest("PUT", url, nil)
+	url := fmt.Sprintf("%s/objects/makebucket/%s", b.hostUrl(host), bucketName)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
 	if err != nil {
-		failHostName(host)
+		b.failHost(host)
 		return err
 	}
 	req.Header.Set("Accept-Encoding", "")
-	response, err := downloadClient.Do(req)
+	response, err := b.do(OpMakeBucket, host, bucketName, "", req)
 	if err != nil {
-		failHostName(host)
-		return err
+		b.failHost(host)
+		return hostError(OpMakeBucket, host, bucketName, "", err)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return errors.New(response.Status)
+		b.answered(host, start, response.StatusCode)
+		return responseError(OpMakeBucket, host, bucketName, "", response, nil)
 	}
-	succeedHostName(host)
+	b.succeedHost(host, start)
 	return nil
 }
 
-func (b *Bucketer) deleteBucketInner(bucketName string) error {
-	host := b.nextBucketHost()
+func (b *Bucketer) deleteBucketInner(ctx context.Context, bucketName string) error {
+	host := b.nextHost(ctx)
+	start := time.Now()
 	//fmt.Printf("delete Bucket %s \n", b.bucket)
-	url := fmt.Sprintf("http://%s/objects/deletebucket/%s", host, bucketName)
-	req, err := http.NewRequest("DELETE", url, nil)
+	url := fmt.Sprintf("%s/objects/deletebucket/%s", b.hostUrl(host), bucketName)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
-		failHostName(host)
+		b.failHost(host)
 		return err
 	}
 	req.Header.Set("Accept-Encoding", "")
-	response, err := downloadClient.Do(req)
+	response, err := b.do(OpDeleteBucket, host, bucketName, "", req)
 	if err != nil {
-		failHostName(host)
-		return err
+		b.failHost(host)
+		return hostError(OpDeleteBucket, host, bucketName, "", err)
 	}
 	defer response.Body.Close()
 
@@ -135,33 +92,34 @@ func (b *Bucketer) deleteBucketInner(bucketName string) error {
 	}
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return errors.New(string(body))
+		b.answered(host, start, response.StatusCode)
+		return responseError(OpDeleteBucket, host, bucketName, "", response, body)
 	}
-	succeedHostName(host)
+	b.succeedHost(host, start)
 	return nil
 }
 
-func (b *Bucketer) listBucketInner() (ListBucketReq, error) {
-	host := b.nextBucketHost()
-	fmt.Printf("list Bucket %s \n", b.bucket)
-	url := fmt.Sprintf("http://%s/objects/listbucket", host)
-	req, err := http.NewRequest("GET", url, nil)
+func (b *Bucketer) listBucketInner(ctx context.Context) (ListBucketReq, error) {
+	host := b.nextHost(ctx)
+	start := time.Now()
+	b.logger().Debug("list buckets", host)
+	url := fmt.Sprintf("%s/objects/listbucket", b.hostUrl(host))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		b.failHost(host)
 		return nil, err
 	}
 	req.Header.Set("Accept-Encoding", "")
-	response, err := downloadClient.Do(req)
+	response, err := b.do(OpListBucket, host, "", "", req)
 	if err != nil {
-		failHostName(host)
-		return nil, err
+		b.failHost(host)
+		return nil, hostError(OpListBucket, host, "", "", err)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return nil, errors.New(response.Status)
+		b.answered(host, start, response.StatusCode)
+		return nil, responseError(OpListBucket, host, "", "", response, nil)
 	}
 
 	body, readErr := ioutil.ReadAll(response.Body)
@@ -174,58 +132,60 @@ func (b *Bucketer) listBucketInner() (ListBucketReq, error) {
 	if jsonErr != nil {
 		return nil, jsonErr
 	}
-	succeedHostName(host)
+	b.succeedHost(host, start)
 	return listReq, nil
 }
 
-func (b *Bucketer) getBucketInfoInner(bucketName string) (string, error) {
-	host := b.nextBucketHost()
-	elog.Infof("get Bucket info %s \n", b.bucket)
-	url := fmt.Sprintf("http://%s/objects/getbucket/%s", host, bucketName)
-	req, err := http.NewRequest("HEAD", url, nil)
+func (b *Bucketer) getBucketInfoInner(ctx context.Context, bucketName string) (string, error) {
+	host := b.nextHost(ctx)
+	start := time.Now()
+	b.logger().Infof("get Bucket info %s \n", b.bucket)
+	url := fmt.Sprintf("%s/objects/getbucket/%s", b.hostUrl(host), bucketName)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
-		failHostName(host)
-		return err.Error(), err
+		b.failHost(host)
+		return "", err
 	}
 	req.Header.Set("Accept-Encoding", "")
-	response, err := downloadClient.Do(req)
+	response, err := b.do(OpGetBucket, host, bucketName, "", req)
 	if err != nil {
-		failHostName(host)
-		return err.Error(), err
+		b.failHost(host)
+		return "", hostError(OpGetBucket, host, bucketName, "", err)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
-		failHostName(host)
-		return err.Error(), errors.New(response.Status)
+		b.answered(host, start, response.StatusCode)
+		return response.Status, responseError(OpGetBucket, host, bucketName, "", response, nil)
 	}
-	succeedHostName(host)
+	b.succeedHost(host, start)
 	return response.Status, nil
 }
 
-func (b *Bucketer) listObjectInfoInner(bucketName, prefix, size, page string) (*ListObjectReq, error) {
-	host := b.nextBucketHost()
-	elog.Infof("list Bucket Object %s \n", b.bucket)
-	url := fmt.Sprintf("http://%s/objects/listobject/%s", host, bucketName)
-	req, err := http.NewRequest("GET", url, nil)
+func (b *Bucketer) listObjectInfoInner(ctx context.Context, bucketName, prefix, size, page string) (*ListObjectReq, error) {
+	host := b.nextHost(ctx)
+	start := time.Now()
+	b.logger().Infof("list Bucket Object %s \n", b.bucket)
+	url := fmt.Sprintf("%s/objects/listobject/%s", b.hostUrl(host), bucketName)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		b.failHost(host)
 		return nil, err
 	}
 	req.Header.Set("Accept-Encoding", "")
 	req.Header.Set("Prefix", prefix)
 	req.Header.Set("size", size)
 	req.Header.Set("Page", page)
-	response, err := bucketClient.Do(req)
+	response, err := b.do(OpListObject, host, bucketName, prefix, req)
 	if err != nil {
-		failHostName(host)
-		return nil, err
+		b.failHost(host)
+		return nil, hostError(OpListObject, host, bucketName, prefix, err)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return nil, errors.New(response.Status)
+		b.answered(host, start, response.StatusCode)
+		return nil, responseError(OpListObject, host, bucketName, prefix, response, nil)
 	}
 
 	body, readErr := ioutil.ReadAll(response.Body)
@@ -238,72 +198,83 @@ func (b *Bucketer) listObjectInfoInner(bucketName, prefix, size, page string) (*
 	if jsonErr != nil {
 		return nil, jsonErr
 	}
-	succeedHostName(host)
+	b.succeedHost(host, start)
 	return &listReq, nil
 }
 
 func NewBucketer(c *Config) *Bucketer {
 
-	var queryer *Queryer = nil
-
 	bucketer := Bucketer{
-		bucket:  c.Bucket,
-		ioHosts: dupStrings(c.IoHosts),
-		queryer: queryer,
+		bucket:     c.Bucket,
+		clientBase: newClientBase(c),
 	}
-	shuffleHosts(bucketer.ioHosts)
 	return &bucketer
 }
 
 func (b *Bucketer) MakeBucket(bucketName string) (err error) {
-	for i := 0; i < 3; i++ {
-		err = b.makeBucketInner(bucketName)
-		if err == nil {
-			return nil
-		}
-	}
-	return err
+	return b.MakeBucketWithContext(context.Background(), bucketName)
+}
+
+func (b *Bucketer) MakeBucketWithContext(ctx context.Context, bucketName string) (err error) {
+	return b.retryPolicy().do(ctx, b.logger(), OpMakeBucket, func() error {
+		return b.makeBucketInner(ctx, bucketName)
+	})
 }
 
 func (b *Bucketer) DeleteBucket(bucketName string) (err error) {
-	for i := 0; i < 3; i++ {
-		err = b.deleteBucketInner(bucketName)
-		if err == nil {
-			return nil
-		}
-	}
-	return err
+	return b.DeleteBucketWithContext(context.Background(), bucketName)
+}
+
+func (b *Bucketer) DeleteBucketWithContext(ctx context.Context, bucketName string) (err error) {
+	return b.retryPolicy().do(ctx, b.logger(), OpDeleteBucket, func() error {
+		return b.deleteBucketInner(ctx, bucketName)
+	})
 }
 
 func (b *Bucketer) ListBucket() (ListBucketReq, error) {
-	var err error
-	for i := 0; i < 3; i++ {
-		list, err := b.listBucketInner()
-		if err == nil {
-			return list, nil
-		}
+	return b.ListBucketWithContext(context.Background())
+}
+
+func (b *Bucketer) ListBucketWithContext(ctx context.Context) (ListBucketReq, error) {
+	var list ListBucketReq
+	err := b.retryPolicy().do(ctx, b.logger(), OpListBucket, func() (err error) {
+		list, err = b.listBucketInner(ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return list, nil
 }
 
 func (b *Bucketer) GetBucketInfo(bucketName string) (string, error) {
-	var err error
-	for i := 0; i < 3; i++ {
-		res, err := b.getBucketInfoInner(bucketName)
-		if err == nil {
-			return res, nil
-		}
+	return b.GetBucketInfoWithContext(context.Background(), bucketName)
+}
+
+func (b *Bucketer) GetBucketInfoWithContext(ctx context.Context, bucketName string) (string, error) {
+	var res string
+	err := b.retryPolicy().do(ctx, b.logger(), OpGetBucket, func() (err error) {
+		res, err = b.getBucketInfoInner(ctx, bucketName)
+		return
+	})
+	if err != nil && res == "" {
+		return err.Error(), err
 	}
-	return err.Error(), err
+	return res, err
 }
 
 func (b *Bucketer) ListObject(bucketName, prefix, size, page string) (*ListObjectReq, error) {
-	var err error
-	for i := 0; i < 3; i++ {
-		list, err := b.listObjectInfoInner(bucketName, prefix, size, page)
-		if err == nil {
-			return list, nil
-		}
+	return b.ListObjectWithContext(context.Background(), bucketName, prefix, size, page)
+}
+
+func (b *Bucketer) ListObjectWithContext(ctx context.Context, bucketName, prefix, size, page string) (*ListObjectReq, error) {
+	var list *ListObjectReq
+	err := b.retryPolicy().do(ctx, b.logger(), OpListObject, func() (err error) {
+		list, err = b.listObjectInfoInner(ctx, bucketName, prefix, size, page)
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return list, nil
 }
@@ -0,0 +1,247 @@
+package operation
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CheckpointExpire is how long an unfinished upload may be resumed, older
+// checkpoints are discarded because the server drops stale uploads.
+var CheckpointExpire = 24 * time.Hour
+
+type uploadCheckpoint struct {
+	File      string           `json:"file"`
+	Bucket    string           `json:"bucket"`
+	Key       string           `json:"key"`
+	Size      int64            `json:"size"`
+	ModTime   int64            `json:"mod_time"`
+	PartSize  int64            `json:"part_size"`
+	UploadId  string           `json:"upload_id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Parts     []checkpointPart `json:"parts"`
+
+	path string
+	m    sync.Mutex
+}
+
+type checkpointPart struct {
+	PartNumber int    `json:"part_number"`
+	Size       int64  `json:"size"`
+	Crc32      uint32 `json:"crc32"`
+}
+
+// checkpointPath returns where the checkpoint of file/key lives, next to the
+// source file unless a checkpoint directory is configured.
+func (p Uploader) checkpointPath(file, key string) string {
+	if p.checkpointDir == "" {
+		return file + ".bstcp"
+	}
+	sum := sha1.Sum([]byte(p.bucket + ":" + key + ":" + absPath(file)))
+	return filepath.Join(p.checkpointDir, hex.EncodeToString(sum[:])+".bstcp")
+}
+
+// absPath is file made absolute, so a checkpoint is found again from another
+// working directory.
+func absPath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	return abs
+}
+
+func loadCheckpoint(path string) (*uploadCheckpoint, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &uploadCheckpoint{}
+	if err = json.Unmarshal(raw, cp); err != nil {
+		return nil, err
+	}
+	cp.path = path
+	return cp, nil
+}
+
+// valid reports whether cp was written for the same upload of an unchanged
+// file. The file is deemed unchanged when its size and modification time
+// are, dropChangedParts catches edits that kept both.
+func (cp *uploadCheckpoint) valid(p Uploader, file, key string, fInfo os.FileInfo) bool {
+	if cp.File != absPath(file) || cp.Bucket != p.bucket || cp.Key != key || cp.UploadId == "" {
+		return false
+	}
+	if cp.Size != fInfo.Size() || cp.ModTime != fInfo.ModTime().UnixNano() || cp.PartSize != p.partSize {
+		return false
+	}
+	if cp.CreatedAt.Add(CheckpointExpire).Before(time.Now()) {
+		return false
+	}
+	partCount := p.partCount(cp.Size)
+	seen := make(map[int]bool, len(cp.Parts))
+	for _, part := range cp.Parts {
+		if part.PartNumber < 1 || part.PartNumber > partCount || seen[part.PartNumber] {
+			return false
+		}
+		offset := int64(part.PartNumber-1) * p.partSize
+		if part.Size != p.partLen(cp.Size, offset) {
+			return false
+		}
+		seen[part.PartNumber] = true
+	}
+	return true
+}
+
+// dropChangedParts hashes the finished parts again and drops those whose
+// data in f no longer matches the crc32 they were sent with, so they are
+// sent again. It returns how many parts were dropped.
+func (cp *uploadCheckpoint) dropChangedParts(f io.ReaderAt, partSize int64) (int, error) {
+	kept := cp.Parts[:0]
+	for _, part := range cp.Parts {
+		h := crc32.NewIEEE()
+		r := io.NewSectionReader(f, int64(part.PartNumber-1)*partSize, part.Size)
+		if _, err := io.Copy(h, r); err != nil {
+			return 0, err
+		}
+		if h.Sum32() == part.Crc32 {
+			kept = append(kept, part)
+		}
+	}
+	dropped := len(cp.Parts) - len(kept)
+	cp.Parts = kept
+	return dropped, nil
+}
+
+func (cp *uploadCheckpoint) addPart(part partInfo, crc uint32) error {
+	cp.m.Lock()
+	defer cp.m.Unlock()
+	cp.Parts = append(cp.Parts, checkpointPart{PartNumber: part.PartNumber, Size: part.Size, Crc32: crc})
+	return cp.save()
+}
+
+// save writes the checkpoint through a temporary file so a crash never
+// leaves a truncated checkpoint behind.
+func (cp *uploadCheckpoint) save() error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(cp.path), 0700); err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func (cp *uploadCheckpoint) remove(log Ilog) {
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		log.Warn("remove checkpoint failed", cp.path, err)
+	}
+}
+
+// resumableUpload is multipartUpload with the progress persisted in a
+// checkpoint file, a later call for the same file and key only sends the
+// parts the server has not accepted yet.
+func (p Uploader) resumableUpload(ctx context.Context, file, key string, f io.ReaderAt, fInfo os.FileInfo, header map[string]string) error {
+	t := time.Now()
+	path := p.checkpointPath(file, key)
+	cp, err := loadCheckpoint(path)
+	if err == nil && !cp.valid(p, file, key, fInfo) {
+		p.logger().Info("discard stale checkpoint", path)
+		p.abortMultipart(key, cp.UploadId)
+		cp.remove(p.logger())
+		cp = nil
+	} else if err != nil && !os.IsNotExist(err) {
+		p.logger().Info("discard broken checkpoint", path, err)
+		os.Remove(path)
+	}
+
+	for i := 0; i < 2; i++ {
+		if cp == nil {
+			var uploadId string
+			err = p.retryPolicy().do(ctx, p.logger(), OpInitMultipart, func() (err error) {
+				uploadId, err = p.initMultipart(ctx, key, header)
+				return
+			})
+			if err != nil {
+				return err
+			}
+			cp = &uploadCheckpoint{
+				File:      absPath(file),
+				Bucket:    p.bucket,
+				Key:       key,
+				Size:      fInfo.Size(),
+				ModTime:   fInfo.ModTime().UnixNano(),
+				PartSize:  p.partSize,
+				UploadId:  uploadId,
+				CreatedAt: time.Now(),
+				path:      path,
+			}
+			if err = cp.save(); err != nil {
+				return err
+			}
+		} else {
+			changed, err := cp.dropChangedParts(f, p.partSize)
+			if err != nil {
+				return err
+			}
+			if changed > 0 {
+				p.logger().Info("resend changed parts", key, cp.UploadId, changed)
+			}
+			p.logger().Info("resume upload", key, cp.UploadId, "finished parts", len(cp.Parts))
+		}
+
+		err = p.uploadCheckpointParts(ctx, cp, f, header)
+		if errors.Is(err, ErrNotFound) {
+			p.logger().Info("upload expired on server, restart", key, cp.UploadId)
+			cp.remove(p.logger())
+			cp = nil
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		p.logger().Info("resumable upload done", key, time.Now().Sub(t))
+		cp.remove(p.logger())
+		return nil
+	}
+	return err
+}
+
+func (p Uploader) uploadCheckpointParts(ctx context.Context, cp *uploadCheckpoint, f io.ReaderAt, header map[string]string) error {
+	finished := make(map[int]bool, len(cp.Parts))
+	for _, part := range cp.Parts {
+		finished[part.PartNumber] = true
+	}
+	sum, err := p.uploadParts(ctx, cp.Key, cp.UploadId, f, cp.Size, func(partNumber int) bool {
+		return finished[partNumber]
+	}, func(part partInfo, crc uint32) {
+		if err := cp.addPart(part, crc); err != nil {
+			p.logger().Warn("save checkpoint failed", cp.path, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if sum != "" {
+		header[checksumHeader] = sum
+	}
+
+	parts := make([]partInfo, p.partCount(cp.Size))
+	for _, part := range cp.Parts {
+		parts[part.PartNumber-1] = partInfo{PartNumber: part.PartNumber, Size: part.Size}
+	}
+	return p.commitMultipart(ctx, cp.Key, cp.UploadId, parts, header)
+}
@@ -0,0 +1,121 @@
+package operation_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// failPart makes the host reject part n of every multipart upload.
+func failPart(s *bsttest.Server, n string) {
+	s.SetFault(&bsttest.Fault{StatusCode: 400, ErrorRate: 1, Match: func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/objects/putpart/") && r.Header.Get("partnumber") == n
+	}})
+}
+
+func TestResumableUpload(t *testing.T) {
+	tests := []struct {
+		name          string
+		checkpointDir bool
+		// between runs the file is touched, edited in place keeping its size
+		// and modification time, or reached through another path
+		touch    bool
+		edit     bool
+		relative bool
+		putparts int
+	}{
+		{"resume next to the file", false, false, false, false, 5},
+		{"resume from the checkpoint dir", true, false, false, false, 5},
+		{"resume through a relative path", true, false, false, true, 5},
+		{"restart after the file changed", false, true, false, false, 7},
+		{"resend a finished part edited in place", false, false, true, false, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			file, data := writeTempFile(t, "obj", 4*4096-100)
+			cfg := &operation.Config{
+				IoHosts:       []string{s.Host()},
+				Bucket:        "bucket",
+				PartSize:      4096,
+				UpConcurrency: 1,
+				Resumable:     true,
+			}
+			if tt.checkpointDir {
+				cfg.CheckpointDir = t.TempDir()
+			}
+			p := operation.NewUploader(cfg)
+			defer p.Close()
+
+			failPart(s, "3")
+			if err := p.Upload(file, "obj", true, false); err == nil {
+				t.Fatal("first upload succeeded")
+			}
+			s.SetFault(nil)
+
+			if tt.touch {
+				later := time.Now().Add(time.Minute)
+				if err := os.Chtimes(file, later, later); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.edit {
+				fi, err := os.Stat(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[0]++
+				if err = ioutil.WriteFile(file, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err = os.Chtimes(file, fi.ModTime(), fi.ModTime()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			name := file
+			if tt.relative {
+				wd, err := os.Getwd()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = os.Chdir(filepath.Dir(file)); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Chdir(wd)
+				name = filepath.Base(file)
+			}
+			if err := p.Upload(name, "obj", true, false); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := s.Object("bucket", "obj")
+			if !bytes.Equal(got, data) {
+				t.Fatalf("stored %d bytes, want %d", len(got), len(data))
+			}
+			if n := s.Count("putpart"); n != tt.putparts {
+				t.Errorf("putpart = %d, want %d", n, tt.putparts)
+			}
+			if n := s.Count("initmultipart"); n != 1+btoi(tt.touch) {
+				t.Errorf("initmultipart = %d", n)
+			}
+			if _, err := os.Stat(file + ".bstcp"); !os.IsNotExist(err) {
+				t.Errorf("checkpoint left behind: %v", err)
+			}
+		})
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+package operation
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Checksum algorithms for Config.Checksum. The checksum travels in the
+// checksum header as "<algorithm>:<hex digest>", the server keeps it with
+// the object and sends it back on download.
+const (
+	ChecksumCRC32C = "crc32c"
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256"
+
+	checksumHeader = "checksum"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+}
+
+// computeChecksum returns the checksum header value of the size bytes of r.
+func computeChecksum(algorithm string, r io.ReaderAt, size int64) (string, error) {
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", err
+	}
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumVerifier hashes what is written to it and compares the result to
+// a checksum header value.
+type checksumVerifier struct {
+	hash.Hash
+	expected string
+}
+
+// newChecksumVerifier returns nil when there is nothing to verify, objects
+// uploaded without checksum or with an algorithm this version does not know
+// are accepted as they are.
+func newChecksumVerifier(value string) *checksumVerifier {
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return nil
+	}
+	h, err := newChecksumHash(value[:i])
+	if err != nil {
+		elog.Debug("skip checksum verification", err)
+		return nil
+	}
+	return &checksumVerifier{Hash: h, expected: strings.ToLower(value)}
+}
+
+func (v *checksumVerifier) actual() string {
+	return v.expected[:strings.Index(v.expected, ":")+1] + hex.EncodeToString(v.Sum(nil))
+}
+
+func (v *checksumVerifier) verify(op Operation, host, bucket, key string) error {
+	if actual := v.actual(); actual != v.expected {
+		return &Error{
+			Op:      op,
+			Host:    host,
+			Bucket:  bucket,
+			Key:     key,
+			Message: fmt.Sprintf("checksum mismatch, expected %s got %s", v.expected, actual),
+			Kind:    ErrChecksumMismatch,
+		}
+	}
+	return nil
+}
+
+// setChecksum adds the checksum header of data when Config.Checksum is set,
+// for uploads sent in a single put. Multipart uploads hash their parts while
+// they are read, see partHasher. Uploads streamed from an io.Reader are not
+// covered, their content is only known once it has been sent.
+func (p *Uploader) setChecksum(header map[string]string, data io.ReaderAt, size int64) error {
+	if p.checksum == "" {
+		return nil
+	}
+	sum, err := computeChecksum(p.checksum, data, size)
+	if err != nil {
+		return err
+	}
+	header[checksumHeader] = sum
+	return nil
+}
+
+// partHasher computes the checksum of a multipart upload from its parts as
+// they are read, so the file is read once. The parts are read in the order
+// of their numbers, each into memory where its attempts are sent from, so
+// with Config.Checksum set up to UpConcurrency parts are held at a time.
+type partHasher struct {
+	algorithm string
+	hash      hash.Hash
+
+	m    sync.Mutex
+	cond *sync.Cond
+	next int
+}
+
+// newPartHasher returns nil when there is no checksum to compute.
+func newPartHasher(algorithm string) (*partHasher, error) {
+	if algorithm == "" {
+		return nil, nil
+	}
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	ph := &partHasher{algorithm: algorithm, hash: h}
+	ph.cond = sync.NewCond(&ph.m)
+	return ph, nil
+}
+
+// read returns the size bytes of part i at offset of data once the parts
+// before it have been read. Every part up to the last one started must be
+// read, or the later ones wait forever.
+func (h *partHasher) read(i int, data io.ReaderAt, offset, size int64) ([]byte, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	for h.next != i {
+		h.cond.Wait()
+	}
+	defer func() {
+		h.next++
+		h.cond.Broadcast()
+	}()
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(data, offset, size), buf); err != nil {
+		return nil, err
+	}
+	h.hash.Write(buf)
+	return buf, nil
+}
+
+// sum is the checksum header value, once every part has been read.
+func (h *partHasher) sum() string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.algorithm + ":" + hex.EncodeToString(h.hash.Sum(nil))
+}
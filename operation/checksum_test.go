@@ -0,0 +1,132 @@
+package operation_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestChecksumRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{operation.ChecksumCRC32C, operation.ChecksumMD5, operation.ChecksumSHA256} {
+		t.Run(algorithm, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			c, err := operation.NewClient(&operation.Config{
+				IoHosts:       []string{s.Host()},
+				Bucket:        "bucket",
+				PartSize:      4096,
+				UpConcurrency: 2,
+				Checksum:      algorithm,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			file, data := writeTempFile(t, "obj", 10000)
+			if err = c.Upload(file, "multipart", true, false); err != nil {
+				t.Fatal(err)
+			}
+			if err = c.UploadBytes(data, "single", true, false); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"multipart", "single"} {
+				if h := s.ObjectHeader("bucket", key).Get("checksum"); !strings.HasPrefix(h, algorithm+":") {
+					t.Errorf("%s stored with checksum %q", key, h)
+				}
+				got, err := c.DownloadBytes(key)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Errorf("%s: %d bytes, %v", key, len(got), err)
+				}
+			}
+		})
+	}
+}
+
+// A resumed upload sends the checksum of the whole file, the parts finished
+// by the first run included.
+func TestChecksumResumedUpload(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	file, data := writeTempFile(t, "obj", 10*4096+7)
+	p := operation.NewUploader(&operation.Config{
+		IoHosts:       []string{s.Host()},
+		Bucket:        "bucket",
+		PartSize:      4096,
+		UpConcurrency: 3,
+		Resumable:     true,
+		Checksum:      operation.ChecksumSHA256,
+	})
+	defer p.Close()
+	failPart(s, "6")
+	if err := p.Upload(file, "obj", true, false); err == nil {
+		t.Fatal("first upload succeeded")
+	}
+	s.SetFault(nil)
+	if err := p.Upload(file, "obj", true, false); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if h, want := s.ObjectHeader("bucket", "obj").Get("checksum"), "sha256:"+hex.EncodeToString(sum[:]); h != want {
+		t.Errorf("stored with checksum %q, want %q", h, want)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		download func(d *operation.Downloader, dir string) error
+	}{
+		{"bytes", func(d *operation.Downloader, dir string) error {
+			_, err := d.DownloadBytes("obj")
+			return err
+		}},
+		{"file", func(d *operation.Downloader, dir string) error {
+			f, err := d.DownloadFile("obj", filepath.Join(dir, "obj"))
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{"parallel", func(d *operation.Downloader, dir string) error {
+			f, err := d.DownloadFileParallel("obj", filepath.Join(dir, "obj"))
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			cfg := &operation.Config{
+				IoHosts:          []string{s.Host()},
+				Bucket:           "bucket",
+				PartSize:         4096,
+				Checksum:         operation.ChecksumSHA256,
+				RetryBaseDelayMs: 1,
+			}
+			p := operation.NewUploader(cfg)
+			defer p.Close()
+			if err := p.UploadBytes(randomBytes(10000), "obj", true, false); err != nil {
+				t.Fatal(err)
+			}
+			s.CorruptObject("bucket", "obj")
+			d := operation.NewDownloader(cfg)
+			defer d.Close()
+			if err := tt.download(d, t.TempDir()); !errors.Is(err, operation.ErrChecksumMismatch) {
+				t.Fatalf("err = %v, want a checksum mismatch", err)
+			}
+			if s.Count("getfile") < 2 {
+				t.Errorf("corrupted download not retried")
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// clientBase is embedded by Uploader, Downloader, Modify and Bucketer. The
+// ones built by a Client share a single clientBase, so they agree on the
+// host scores, the retry policy, the connection pool and the logger, and a
+// setter called on any of them applies to all of them. Only the rate limits
+// of the uploader and the downloader are their own.
+type clientBase struct {
+	*hostSelector
+	transport    http.RoundTripper
+	ownTransport bool
+	timeouts     timeouts
+	scheme       string
+
+	// settings guards the fields below, their setters may run while
+	// requests are in flight
+	settings     sync.RWMutex
+	retry        *RetryPolicy
+	progress     ProgressListener
+	signer       *Signer
+	interceptors []Interceptor
+}
+
+func newClientBase(c *Config) *clientBase {
+	scheme, transport := transportSettings(c)
+	timeouts := newTimeouts(c)
+	return &clientBase{
+		hostSelector: newHostSelector(c, scheme, transport, timeouts),
+		retry:        NewRetryPolicy(c),
+		transport:    transport,
+		ownTransport: c.Transport == nil,
+		timeouts:     timeouts,
+		scheme:       scheme,
+		signer:       NewSigner(credentialsFromConfig(c)),
+	}
+}
+
+// Close stops the health checks and drops the idle connections of the
+// transport, unless it came from Config.Transport. With
+// HealthCheckIntervalMs set it must be called once the client is no longer
+// used, the health check started by the first request runs until then.
+func (b *clientBase) Close() error {
+	if t, ok := b.transport.(interface{ CloseIdleConnections() }); ok && b.ownTransport {
+		t.CloseIdleConnections()
+	}
+	return b.hostSelector.Close()
+}
+
+// SetRetryPolicy replaces the retry policy, requests that already started
+// keep the old one.
+func (b *clientBase) SetRetryPolicy(r *RetryPolicy) {
+	b.settings.Lock()
+	defer b.settings.Unlock()
+	b.retry = r
+}
+
+func (b *clientBase) retryPolicy() *RetryPolicy {
+	b.settings.RLock()
+	defer b.settings.RUnlock()
+	return b.retry
+}
+
+// SetLogger replaces the logger of this client only, SetLogger at package
+// level still applies to clients without one.
+func (b *clientBase) SetLogger(l Ilog) {
+	b.hostSelector.setLogger(l)
+}
+
+// Client bundles the upload, download, modify and bucket operations on the
+// bucket of one Config. Object operations are promoted from the embedded
+// clients, the bucket listings of Bucketer are reached as
+// c.Bucketer.ListObject and c.Bucketer.Objects because ListObject and Objects
+// on the Client list the configured bucket.
+type Client struct {
+	*Uploader
+	*Downloader
+	*Modify
+	*Bucketer
+
+	base *clientBase
+}
+
+var (
+	_ ClientInterface   = (*Client)(nil)
+	_ UploadInterface   = (*Uploader)(nil)
+	_ DownloadInterface = (*Downloader)(nil)
+	_ ModifyInterface   = (*Modify)(nil)
+	_ BucketInterface   = (*Bucketer)(nil)
+)
+
+func NewClient(c *Config) (*Client, error) {
+	if c == nil {
+		return nil, errors.New("nil config")
+	}
+	if len(c.IoHosts) == 0 && len(c.UcHosts) == 0 {
+		return nil, errors.New("no io_hosts or uc_hosts configured")
+	}
+	if err := checkTransport(c); err != nil {
+		return nil, err
+	}
+	base := newClientBase(c)
+	return &Client{
+		Uploader: &Uploader{
+			clientBase:    base,
+			limiter:       NewRateLimiter(c.UpRateLimit),
+			bucket:        c.Bucket,
+			partSize:      c.PartSize,
+			upConcurrency: c.UpConcurrency,
+			resumable:     c.Resumable,
+			checkpointDir: c.CheckpointDir,
+			checksum:      c.Checksum,
+		},
+		Downloader: &Downloader{
+			clientBase:      base,
+			limiter:         NewRateLimiter(c.DownRateLimit),
+			bucket:          c.Bucket,
+			partSize:        c.PartSize,
+			downConcurrency: c.DownConcurrency,
+			cache:           NewBlockCache(c),
+		},
+		Modify: &Modify{
+			clientBase:       base,
+			bucket:           c.Bucket,
+			batchConcurrency: c.BatchConcurrency,
+			partSize:         c.PartSize,
+		},
+		Bucketer: &Bucketer{
+			clientBase: base,
+			bucket:     c.Bucket,
+		},
+		base: base,
+	}, nil
+}
+
+func NewClientV2() (*Client, error) {
+	c := getConf()
+	if c == nil {
+		return nil, errors.New("load config from STORE failed")
+	}
+	return NewClient(c)
+}
+
+func (c *Client) SetRetryPolicy(r *RetryPolicy) {
+	c.base.SetRetryPolicy(r)
+}
+
+func (c *Client) SetBalancer(balancer Balancer) {
+	c.base.SetBalancer(balancer)
+}
+
+func (c *Client) SetLogger(l Ilog) {
+	c.base.SetLogger(l)
+}
+
+func (c *Client) SetUploadRateLimit(bytesPerSec int64) {
+	c.Uploader.SetRateLimit(bytesPerSec)
+}
+
+func (c *Client) SetDownloadRateLimit(bytesPerSec int64) {
+	c.Downloader.SetRateLimit(bytesPerSec)
+}
+
+func (c *Client) SetProgressListener(l ProgressListener) {
+	c.base.SetProgressListener(l)
+}
+
+func (c *Client) Use(interceptors ...Interceptor) {
+	c.base.Use(interceptors...)
+}
+
+func (c *Client) SetCredentials(provider CredentialsProvider) {
+	c.base.SetCredentials(provider)
+}
+
+// ListObject lists the configured bucket, see Modify.ListObject.
+func (c *Client) ListObject(prefix string, size int) (*BstFiles, error) {
+	return c.Modify.ListObject(prefix, size)
+}
+
+func (c *Client) ListObjectWithContext(ctx context.Context, prefix string, size int) (*BstFiles, error) {
+	return c.Modify.ListObjectWithContext(ctx, prefix, size)
+}
+
+// Objects walks the configured bucket, see Modify.Objects.
+func (c *Client) Objects(opts *ListOptions) *ObjectIterator {
+	return c.Modify.Objects(opts)
+}
+
+func (c *Client) ObjectsWithContext(ctx context.Context, opts *ListOptions) *ObjectIterator {
+	return c.Modify.ObjectsWithContext(ctx, opts)
+}
+
+// Close stops the background work shared by all operations of the client.
+func (c *Client) Close() error {
+	return c.base.Close()
+}
@@ -0,0 +1,213 @@
+package operation_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// newTestClient starts a server with the bucket "bucket" holding seed, key
+// to content, and makes a Client on that bucket. Keys ending in a slash are
+// seeded as folder objects. configure, when not nil, adjusts the Config
+// before the Client is made. Both are closed when the test ends.
+func newTestClient(t *testing.T, seed map[string]string, configure func(c *operation.Config)) (*bsttest.Server, *operation.Client) {
+	t.Helper()
+	s := bsttest.NewServer("bucket")
+	t.Cleanup(s.Close)
+	cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"}
+	if configure != nil {
+		configure(cfg)
+	}
+	c, err := operation.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	for key, content := range seed {
+		if strings.HasSuffix(key, "/") {
+			if err = c.UploadFloder(nil, strings.TrimSuffix(key, "/"), true); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		s.PutObject("bucket", key, []byte(content))
+	}
+	return s, c
+}
+
+func TestNewClientChecksConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *operation.Config
+	}{
+		{"nil config", nil},
+		{"no hosts", &operation.Config{Bucket: "bucket"}},
+		{"bad scheme", &operation.Config{IoHosts: []string{"h:1"}, Scheme: "ftp"}},
+		{"bad proxy", &operation.Config{IoHosts: []string{"h:1"}, Proxy: "::"}},
+		{"missing ca file", &operation.Config{IoHosts: []string{"h:1"}, Scheme: "https", CaFile: "/nonexistent"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := operation.NewClient(tt.cfg); err == nil {
+				c.Close()
+				t.Fatal("no error")
+			}
+		})
+	}
+}
+
+// The operations of a Client share their retry policy, set through any of
+// them it applies to all of them.
+func TestClientSharesRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(c *operation.Client, r *operation.RetryPolicy)
+	}{
+		{"client", func(c *operation.Client, r *operation.RetryPolicy) { c.SetRetryPolicy(r) }},
+		{"uploader", func(c *operation.Client, r *operation.RetryPolicy) { c.Uploader.SetRetryPolicy(r) }},
+		{"downloader", func(c *operation.Client, r *operation.RetryPolicy) { c.Downloader.SetRetryPolicy(r) }},
+		{"modify", func(c *operation.Client, r *operation.RetryPolicy) { c.Modify.SetRetryPolicy(r) }},
+		{"bucketer", func(c *operation.Client, r *operation.RetryPolicy) { c.Bucketer.SetRetryPolicy(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", []byte("data"))
+			s.SetFault(&bsttest.Fault{ErrorRate: 1})
+			c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			tt.apply(c, &operation.RetryPolicy{MaxAttempts: 4})
+			if _, err = c.DownloadBytes("obj"); err == nil {
+				t.Fatal("download through a failing host succeeded")
+			}
+			if err = c.DeleteFile("obj"); err == nil {
+				t.Fatal("delete through a failing host succeeded")
+			}
+			if n := s.Count("getfile"); n != 4 {
+				t.Errorf("%d downloads, want 4", n)
+			}
+			if n := s.Count("deletefile"); n != 4 {
+				t.Errorf("%d deletes, want 4", n)
+			}
+		})
+	}
+}
+
+// The operations of a Client share their interceptors, added through any of
+// them they see the requests of all of them.
+func TestClientSharesInterceptors(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(c *operation.Client, i operation.Interceptor)
+	}{
+		{"client", func(c *operation.Client, i operation.Interceptor) { c.Use(i) }},
+		{"uploader", func(c *operation.Client, i operation.Interceptor) { c.Uploader.Use(i) }},
+		{"downloader", func(c *operation.Client, i operation.Interceptor) { c.Downloader.Use(i) }},
+		{"modify", func(c *operation.Client, i operation.Interceptor) { c.Modify.Use(i) }},
+		{"bucketer", func(c *operation.Client, i operation.Interceptor) { c.Bucketer.Use(i) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			var (
+				m   sync.Mutex
+				ops = make(map[operation.Operation]bool)
+			)
+			tt.apply(c, func(info *operation.RequestInfo, req *http.Request, next operation.Handler) (*http.Response, error) {
+				m.Lock()
+				ops[info.Op] = true
+				m.Unlock()
+				return next(info, req)
+			})
+			if err = c.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = c.DownloadBytes("obj"); err != nil {
+				t.Fatal(err)
+			}
+			if err = c.DeleteFile("obj"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = c.ListBucket(); err != nil {
+				t.Fatal(err)
+			}
+			for _, op := range []operation.Operation{operation.OpUpload, operation.OpDownload, operation.OpDelete, operation.OpListBucket} {
+				if !ops[op] {
+					t.Errorf("%s not intercepted", op)
+				}
+			}
+		})
+	}
+}
+
+func TestClientSharesCredentials(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetCredentials("ak", "sk")
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = c.UploadBytes([]byte("data"), "obj", true, false); !errors.Is(err, operation.ErrAccessDenied) {
+		t.Fatalf("unsigned upload: %v", err)
+	}
+	c.Downloader.SetCredentials(operation.StaticCredentials("ak", "sk"))
+	if err = c.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.MetaInfo("obj"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The setters of a Client may run while its requests are in flight, go test
+// -race catches them racing with the requests.
+func TestClientSettersWhileRunning(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.PutObject("bucket", "obj", []byte("data"))
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := c.DownloadBytes("obj"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		c.SetRetryPolicy(&operation.RetryPolicy{MaxAttempts: 2})
+		c.SetLogger(&recordLogger{})
+		c.SetProgressListener(func(operation.Progress) {})
+		c.SetCredentials(nil)
+		c.Use(func(info *operation.RequestInfo, req *http.Request, next operation.Handler) (*http.Response, error) {
+			return next(info, req)
+		})
+	}
+	wg.Wait()
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/pelletier/go-toml"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -15,11 +16,50 @@ import (
 )
 
 type Config struct {
-	IoHosts       []string `json:"io_hosts" toml:"io_hosts"`
-	Bucket        string   `json:"bucket" toml:"bucket"`
-	PartSize      int64    `json:"part" toml:"part"`
-	Retry         int      `json:"retry" toml:"retry"`
-	BaseTimeoutMs int64    `json:"base_timeout_ms" toml:"base_timeout_ms"`
+	IoHosts               []string          `json:"io_hosts" toml:"io_hosts"`
+	UcHosts               []string          `json:"uc_hosts" toml:"uc_hosts"`
+	Scheme                string            `json:"scheme" toml:"scheme"`
+	CaFile                string            `json:"ca_file" toml:"ca_file"`
+	CertFile              string            `json:"cert_file" toml:"cert_file"`
+	KeyFile               string            `json:"key_file" toml:"key_file"`
+	ServerName            string            `json:"server_name" toml:"server_name"`
+	AccessKey             string            `json:"ak" toml:"ak"`
+	SecretKey             string            `json:"sk" toml:"sk"`
+	CredentialsFile       string            `json:"credentials_file" toml:"credentials_file"`
+	Balancer              string            `json:"balancer" toml:"balancer"`
+	HealthCheckIntervalMs int64             `json:"health_check_interval_ms" toml:"health_check_interval_ms"`
+	Bucket                string            `json:"bucket" toml:"bucket"`
+	PartSize              int64             `json:"part" toml:"part"`
+	UpConcurrency         int               `json:"up_concurrency" toml:"up_concurrency"`
+	DownConcurrency       int               `json:"down_concurrency" toml:"down_concurrency"`
+	BatchConcurrency      int               `json:"batch_concurrency" toml:"batch_concurrency"`
+	Resumable             bool              `json:"resumable" toml:"resumable"`
+	CheckpointDir         string            `json:"checkpoint_dir" toml:"checkpoint_dir"`
+	Checksum              string            `json:"checksum" toml:"checksum"`
+	UpRateLimit           int64             `json:"up_rate_limit" toml:"up_rate_limit"`
+	DownRateLimit         int64             `json:"down_rate_limit" toml:"down_rate_limit"`
+	BlockCacheSize        int64             `json:"block_cache_size" toml:"block_cache_size"`
+	BlockSize             int64             `json:"block_size" toml:"block_size"`
+	BlockCacheDir         string            `json:"block_cache_dir" toml:"block_cache_dir"`
+	BlockCacheDiskSize    int64             `json:"block_cache_disk_size" toml:"block_cache_disk_size"`
+	BlockCacheTtlMs       int64             `json:"block_cache_ttl_ms" toml:"block_cache_ttl_ms"`
+	ReadAhead             int               `json:"read_ahead" toml:"read_ahead"`
+	Retry                 int               `json:"retry" toml:"retry"`
+	RetryBaseDelayMs      int64             `json:"retry_base_delay_ms" toml:"retry_base_delay_ms"`
+	RetryMaxDelayMs       int64             `json:"retry_max_delay_ms" toml:"retry_max_delay_ms"`
+	BaseTimeoutMs         int64             `json:"base_timeout_ms" toml:"base_timeout_ms"`
+	OpTimeoutsMs          map[string]int64  `json:"op_timeouts_ms" toml:"op_timeouts_ms"`
+	DialTimeoutMs         int64             `json:"dial_timeout_ms" toml:"dial_timeout_ms"`
+	KeepAliveMs           int64             `json:"keep_alive_ms" toml:"keep_alive_ms"`
+	DisableKeepAlives     bool              `json:"disable_keep_alives" toml:"disable_keep_alives"`
+	MaxIdleConns          int               `json:"max_idle_conns" toml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int               `json:"max_idle_conns_per_host" toml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int               `json:"max_conns_per_host" toml:"max_conns_per_host"`
+	IdleConnTimeoutMs     int64             `json:"idle_conn_timeout_ms" toml:"idle_conn_timeout_ms"`
+	Http2                 bool              `json:"http2" toml:"http2"`
+	Proxy                 string            `json:"proxy" toml:"proxy"`
+	UnixSocket            string            `json:"unix_socket" toml:"unix_socket"`
+	Transport             http.RoundTripper `json:"-" toml:"-"`
 }
 
 func dupStrings(s []string) []string {
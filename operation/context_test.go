@@ -0,0 +1,107 @@
+package operation_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestContextCancelsRequests(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.PutObject("bucket", "obj", []byte("data"))
+	s.SetFault(&bsttest.Fault{Latency: time.Second})
+	cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"}
+	d := operation.NewDownloader(cfg)
+	defer d.Close()
+	m := operation.NewModifier(cfg)
+	defer m.Close()
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"download bytes", func(ctx context.Context) error {
+			_, err := d.DownloadBytesWithContext(ctx, "obj")
+			return err
+		}},
+		{"range reader", func(ctx context.Context) error {
+			_, _, err := d.DownloadRangeReaderWithContext(ctx, "obj", 0, 2)
+			return err
+		}},
+		{"meta info", func(ctx context.Context) error {
+			_, err := m.MetaInfoWithContext(ctx, "obj")
+			return err
+		}},
+		{"delete", func(ctx context.Context) error {
+			return m.DeleteFileWithContext(ctx, "obj")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := tt.call(ctx)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("err = %v, want the deadline", err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("returned after %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestRangeReaderRetriesOnAnotherHost(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(d *operation.Downloader) ([]byte, error)
+	}{
+		{"range reader", func(d *operation.Downloader) ([]byte, error) {
+			_, r, err := d.DownloadRangeReader("obj", 2, 3)
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		}},
+		{"raw", func(d *operation.Downloader) ([]byte, error) {
+			resp, err := d.DownloadRaw("obj", http.Header{"Range": {"bytes=2-5"}})
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent {
+				return nil, errors.New(resp.Status)
+			}
+			return ioutil.ReadAll(resp.Body)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := bsttest.NewCluster(2, "bucket")
+			defer c.Close()
+			c[0].PutObject("bucket", "obj", []byte("0123456789"))
+			c[0].SetFault(&bsttest.Fault{DropRate: 1})
+			d := operation.NewDownloader(&operation.Config{IoHosts: c.Hosts(), Bucket: "bucket", Retry: 2})
+			defer d.Close()
+			for i := 0; i < 3; i++ {
+				got, err := tt.call(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, []byte("2345")) {
+					t.Fatalf("got %q", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var errCopyUnsupported = errors.New("server side copy not supported")
+
+func (d *Modify) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	return d.CopyObjectWithContext(context.Background(), srcBucket, srcKey, dstBucket, dstKey, overwrite)
+}
+
+// CopyObjectWithContext copies srcBucket/srcKey to dstBucket/dstKey with the
+// copy endpoint of the server. When the server has none the object is
+// streamed through the client instead, checked against the checksum of the
+// source when it has one and against its size otherwise. The client
+// remembers that the endpoint is missing and streams from then on.
+func (d *Modify) CopyObjectWithContext(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	if atomic.LoadInt32(&d.noServerCopy) == 0 {
+		unsupported := false
+		err := d.retryPolicy().do(ctx, d.logger(), OpCopy, func() error {
+			err := d.copyInner(ctx, srcBucket, srcKey, dstBucket, dstKey, overwrite)
+			if err == errCopyUnsupported {
+				unsupported = true
+				return nil
+			}
+			return err
+		})
+		if !unsupported {
+			return err
+		}
+		d.logger().Info("server side copy not supported, streaming", srcBucket, srcKey)
+		atomic.StoreInt32(&d.noServerCopy, 1)
+	}
+	return d.retryPolicy().do(ctx, d.logger(), OpCopy, func() error {
+		return d.streamCopy(ctx, srcBucket, srcKey, dstBucket, dstKey, overwrite)
+	})
+}
+
+func (d *Modify) MoveObject(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	return d.MoveObjectWithContext(context.Background(), srcBucket, srcKey, dstBucket, dstKey, overwrite)
+}
+
+// MoveObjectWithContext copies the object like CopyObject and deletes the
+// source once the copy is complete. A failed delete leaves both objects.
+func (d *Modify) MoveObjectWithContext(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	if srcBucket == dstBucket && srcKey == dstKey {
+		return nil
+	}
+	if err := d.CopyObjectWithContext(ctx, srcBucket, srcKey, dstBucket, dstKey, overwrite); err != nil {
+		return err
+	}
+	src := &Modify{bucket: srcBucket, clientBase: d.clientBase}
+	return src.DeleteFileWithContext(ctx, srcKey)
+}
+
+func (d *Modify) copyInner(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	host := d.nextHost(ctx)
+	start := time.Now()
+	url := fmt.Sprintf("%s/objects/copy/%s/%s", d.hostUrl(host), dstBucket, dstKey)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
+	if err != nil {
+		d.failHost(host)
+		return err
+	}
+	req.Header.Set("Accept-Encoding", "")
+	req.Header.Set("srcbucket", srcBucket)
+	req.Header.Set("object", srcKey)
+	req.Header.Set("overwrite", strconv.FormatBool(overwrite))
+	response, err := d.do(OpCopy, host, srcBucket, srcKey, req)
+	if err != nil {
+		d.failHost(host)
+		return hostError(OpCopy, host, srcBucket, srcKey, err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	switch {
+	case response.StatusCode == http.StatusOK:
+		d.succeedHost(host, start)
+		return nil
+	case response.StatusCode == http.StatusNotImplemented || response.StatusCode == http.StatusMethodNotAllowed,
+		response.StatusCode == http.StatusNotFound && strings.Contains(strings.ToLower(string(body)), "page not found"):
+		d.succeedHost(host, start)
+		return errCopyUnsupported
+	case response.StatusCode < http.StatusInternalServerError:
+		d.succeedHost(host, start)
+	default:
+		d.failHost(host)
+	}
+	return responseError(OpCopy, host, srcBucket, srcKey, response, body)
+}
+
+// streamCopy uploads the body of a download of the source as it arrives.
+func (d *Modify) streamCopy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	src := &Modify{bucket: srcBucket, clientBase: d.clientBase}
+	meta, err := src.metaInfoInner(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+
+	host := d.nextHost(ctx)
+	start := time.Now()
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), srcBucket, srcKey)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		d.failHost(host)
+		return err
+	}
+	req.Header.Set("Accept-Encoding", "")
+	response, err := d.do(OpCopy, host, srcBucket, srcKey, req)
+	if err != nil {
+		d.failHost(host)
+		return hostError(OpCopy, host, srcBucket, srcKey, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(response.Body)
+		d.answered(host, start, response.StatusCode)
+		return responseError(OpCopy, host, srcBucket, srcKey, response, body)
+	}
+	d.succeedHost(host, start)
+
+	header := map[string]string{
+		"overwrite": strconv.FormatBool(overwrite),
+		"blocksize": strconv.FormatInt(d.partSize, 10),
+	}
+	if meta.Dir {
+		header["floder"] = dstKey
+	}
+	// the server checks the upload against the checksum of the source, the
+	// verifier catches a source that was corrupted on the way to us
+	var body io.Reader = response.Body
+	checksum := response.Header.Get(checksumHeader)
+	verifier := newChecksumVerifier(checksum)
+	if verifier != nil {
+		header[checksumHeader] = checksum
+		body = io.TeeReader(body, verifier)
+	}
+	up := &Uploader{bucket: dstBucket, clientBase: d.clientBase}
+	if err = up.put(ctx, nil, dstKey, body, meta.Size, dstBucket, header); err != nil {
+		return err
+	}
+
+	dst := &Modify{bucket: dstBucket, clientBase: d.clientBase}
+	if verifier != nil {
+		err = verifier.verify(OpCopy, host, srcBucket, srcKey)
+	} else if copied, statErr := dst.metaInfoInner(ctx, dstKey); statErr != nil {
+		err = statErr
+	} else if copied.Size != meta.Size {
+		err = &Error{Op: OpCopy, Bucket: dstBucket, Key: dstKey, Kind: ErrChecksumMismatch,
+			Message: fmt.Sprintf("copied %d bytes of %d", copied.Size, meta.Size)}
+	}
+	if err != nil {
+		if delErr := dst.deleteFileInner(ctx, dstKey); delErr != nil {
+			d.logger().Warn("remove bad copy failed", dstBucket, dstKey, delErr)
+		}
+	}
+	return err
+}
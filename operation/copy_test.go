@@ -0,0 +1,120 @@
+package operation_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestCopyObject(t *testing.T) {
+	noCopy := &bsttest.Fault{ErrorRate: 1, StatusCode: http.StatusNotImplemented, Match: isAction("copy")}
+	tests := []struct {
+		name      string
+		fault     *bsttest.Fault
+		checksum  string
+		move      bool
+		dstBucket string
+		dstKey    string
+		overwrite bool
+		err       error
+	}{
+		{"server copy", nil, "", false, "bucket", "dst", false, nil},
+		{"server copy to another bucket", nil, "", false, "other", "src", false, nil},
+		{"server copy onto an existing key", nil, "", false, "bucket", "existing", false, operation.ErrAlreadyExists},
+		{"server copy overwriting", nil, "", false, "bucket", "existing", true, nil},
+		{"streamed copy", noCopy, "", false, "bucket", "dst", false, nil},
+		{"streamed copy with checksum", noCopy, operation.ChecksumSHA256, false, "bucket", "dst", false, nil},
+		{"streamed copy to another bucket", noCopy, "", false, "other", "src", false, nil},
+		{"streamed copy onto an existing key", noCopy, "", false, "bucket", "existing", false, operation.ErrAlreadyExists},
+		{"move", nil, "", true, "other", "dst", false, nil},
+		{"streamed move", noCopy, "", true, "other", "dst", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket", "other")
+			defer s.Close()
+			s.SetFault(tt.fault)
+			cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1, Checksum: tt.checksum}
+			c, err := operation.NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			data := randomBytes(5000)
+			if err = c.UploadBytes(data, "src", true, false); err != nil {
+				t.Fatal(err)
+			}
+			s.PutObject("bucket", "existing", []byte("old"))
+
+			if tt.move {
+				err = c.MoveObject("bucket", "src", tt.dstBucket, tt.dstKey, tt.overwrite)
+			} else {
+				err = c.CopyObject("bucket", "src", tt.dstBucket, tt.dstKey, tt.overwrite)
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := s.Object(tt.dstBucket, tt.dstKey); !ok || !bytes.Equal(got, data) {
+				t.Errorf("copy of %d bytes", len(got))
+			}
+			if _, ok := s.Object("bucket", "src"); ok == tt.move {
+				t.Errorf("source kept %v", ok)
+			}
+		})
+	}
+}
+
+// A client learns from the first copy that the server cannot copy and
+// streams the next ones without asking again.
+func TestCopyObjectRemembersFallback(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetFault(&bsttest.Fault{ErrorRate: 1, StatusCode: http.StatusNotImplemented, Match: isAction("copy")})
+	s.PutObject("bucket", "src", []byte("data"))
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, dst := range []string{"a", "b", "c"} {
+		if err = c.CopyObject("bucket", "src", "bucket", dst, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.Count("copy"); n != 1 {
+		t.Errorf("copy endpoint asked %d times", n)
+	}
+}
+
+// A source corrupted on the way through the client fails the streamed copy
+// and leaves no copy behind.
+func TestCopyObjectStreamedCorruption(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetFault(&bsttest.Fault{ErrorRate: 1, StatusCode: http.StatusNotImplemented, Match: isAction("copy")})
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1, Checksum: operation.ChecksumSHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = c.UploadBytes(randomBytes(5000), "src", true, false); err != nil {
+		t.Fatal(err)
+	}
+	s.CorruptObject("bucket", "src")
+	if err = c.CopyObject("bucket", "src", "bucket", "dst", false); !errors.Is(err, operation.ErrChecksumMismatch) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := s.Object("bucket", "dst"); ok {
+		t.Error("corrupted copy left behind")
+	}
+}
@@ -0,0 +1,353 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirOptions tunes UploadDir and DownloadDir, nil uses the defaults.
+//
+// Include and Exclude are path.Match patterns matched against the path of a
+// file relative to the directory, or against its base name when the pattern
+// has no "/". With Include set only matching files are transferred, Exclude
+// wins over Include. A directory matching Exclude is skipped with everything
+// under it, Include only selects files. Files whose size and modification
+// time match the other side are skipped unless Force is set. Concurrency
+// defaults to the concurrency of the client.
+type DirOptions struct {
+	Include     []string
+	Exclude     []string
+	Concurrency int
+	Force       bool
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *DirOptions) match(rel string) bool {
+	if o == nil {
+		return true
+	}
+	if len(o.Include) > 0 && !matchAny(o.Include, rel) {
+		return false
+	}
+	return !matchAny(o.Exclude, rel)
+}
+
+// skipDir tells whether the directory rel or one of its parents is
+// excluded.
+func (o *DirOptions) skipDir(rel string) bool {
+	if o == nil {
+		return false
+	}
+	for ; rel != "." && rel != "/"; rel = path.Dir(rel) {
+		if matchAny(o.Exclude, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *DirOptions) concurrency(fallback int) int {
+	if o != nil && o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	if fallback > 0 {
+		return fallback
+	}
+	return 1
+}
+
+// DirResult is the outcome for one file or directory marker.
+type DirResult struct {
+	Path    string
+	Key     string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+	Skipped bool
+	Err     error
+}
+
+// DirReport lists the results sorted by key.
+type DirReport struct {
+	Results     []DirResult
+	Transferred int
+	Skipped     int
+	Failed      int
+	Bytes       int64
+}
+
+func (r *DirReport) add(res DirResult) {
+	r.Results = append(r.Results, res)
+	switch {
+	case res.Err != nil:
+		r.Failed++
+	case res.Skipped:
+		r.Skipped++
+	default:
+		r.Transferred++
+		r.Bytes += res.Size
+	}
+}
+
+// err sorts the results and sums up the failures, the first one is wrapped.
+func (r *DirReport) err() error {
+	sort.Slice(r.Results, func(i, j int) bool {
+		return r.Results[i].Key < r.Results[j].Key
+	})
+	if r.Failed == 0 {
+		return nil
+	}
+	for _, res := range r.Results {
+		if res.Err != nil {
+			return fmt.Errorf("%d of %d transfers failed, first %s: %w", r.Failed, len(r.Results), res.Key, res.Err)
+		}
+	}
+	return nil
+}
+
+// runDirJobs runs jobs on n goroutines until ctx is done, the jobs not
+// started by then fail with the error of ctx.
+func runDirJobs(ctx context.Context, n int, jobs []DirResult, do func(*DirResult)) *DirReport {
+	errs := runBatch(ctx, n, len(jobs), func(i int) error {
+		do(&jobs[i])
+		return nil
+	})
+	report := &DirReport{}
+	for i, job := range jobs {
+		if errs[i] != nil {
+			job.Err = errs[i]
+		}
+		report.add(job)
+	}
+	return report
+}
+
+// dirPrefix is the listing prefix of the objects under prefix.
+func dirPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func (p *Uploader) UploadDir(localDir, prefix string, opts *DirOptions) (*DirReport, error) {
+	return p.UploadDirWithContext(context.Background(), localDir, prefix, opts)
+}
+
+// UploadDirWithContext uploads the tree under localDir to prefix, every
+// directory gets a floder marker. The report is returned along with the
+// error when some files failed.
+func (p *Uploader) UploadDirWithContext(ctx context.Context, localDir, prefix string, opts *DirOptions) (*DirReport, error) {
+	base := dirPrefix(prefix)
+	m := &Modify{bucket: p.bucket, clientBase: p.clientBase}
+	files, err := m.listAll(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	remote := make(map[string]BstFile, len(files))
+	for _, f := range files {
+		remote[strings.TrimSuffix(f.Name, "/")] = f
+	}
+
+	var markers, jobs []DirResult
+	err = filepath.Walk(localDir, func(local string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, local)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		key := base + rel
+		if info.IsDir() {
+			if opts.skipDir(rel) {
+				return filepath.SkipDir
+			}
+			old, ok := remote[key]
+			markers = append(markers, DirResult{Path: local, Key: key, Dir: true, Skipped: ok && old.Dir})
+			return nil
+		}
+		if !info.Mode().IsRegular() || !opts.match(rel) {
+			return nil
+		}
+		old, ok := remote[key]
+		unchanged := ok && !old.Dir && old.Size == info.Size() && old.Time >= info.ModTime().Unix()
+		jobs = append(jobs, DirResult{
+			Path:    local,
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Skipped: unchanged && (opts == nil || !opts.Force),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// markers go first, they are small and a directory should exist before
+	// its files show up
+	report := runDirJobs(ctx, 1, markers, func(res *DirResult) {
+		if !res.Skipped {
+			res.Err = p.UploadFloderWithContext(ctx, nil, res.Key, true)
+		}
+	})
+	uploads := runDirJobs(ctx, opts.concurrency(p.upConcurrency), jobs, func(res *DirResult) {
+		if res.Skipped {
+			return
+		}
+		t := time.Now()
+		res.Err = p.UploadWithContext(ctx, res.Path, res.Key, true, false)
+		p.logger().Debug("dir upload", res.Key, time.Now().Sub(t), res.Err)
+	})
+	for _, res := range uploads.Results {
+		report.add(res)
+	}
+	return report, report.err()
+}
+
+func (d *Downloader) DownloadDir(prefix, localDir string, opts *DirOptions) (*DirReport, error) {
+	return d.DownloadDirWithContext(context.Background(), prefix, localDir, opts)
+}
+
+// DownloadDirWithContext downloads every object under prefix into localDir,
+// directory markers become empty directories. A file is written next to its
+// destination first and renamed when complete, an interrupted run continues
+// where it stopped. Downloaded files get the modification time of the object
+// so that the next run can skip them.
+func (d *Downloader) DownloadDirWithContext(ctx context.Context, prefix, localDir string, opts *DirOptions) (*DirReport, error) {
+	base := dirPrefix(prefix)
+	m := &Modify{bucket: d.bucket, clientBase: d.clientBase}
+	files, err := m.listAll(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(localDir, 0755); err != nil {
+		return nil, err
+	}
+
+	report := &DirReport{}
+	var jobs []DirResult
+	for _, f := range files {
+		rel := strings.Trim(strings.TrimPrefix(f.Name, base), "/")
+		if rel == "" || strings.Contains("/"+rel+"/", "/../") {
+			continue
+		}
+		local := filepath.Join(localDir, filepath.FromSlash(rel))
+		if f.Dir {
+			if opts.skipDir(rel) {
+				continue
+			}
+			res := DirResult{Path: local, Key: f.Name, Dir: true}
+			if info, err := os.Stat(local); err == nil && info.IsDir() {
+				res.Skipped = true
+			} else {
+				res.Err = os.MkdirAll(local, 0755)
+			}
+			report.add(res)
+			continue
+		}
+		if opts.skipDir(path.Dir(rel)) || !opts.match(rel) {
+			continue
+		}
+		info, err := os.Stat(local)
+		unchanged := err == nil && info.Size() == f.Size && info.ModTime().Unix() == f.Time
+		jobs = append(jobs, DirResult{
+			Path:    local,
+			Key:     f.Name,
+			Size:    f.Size,
+			ModTime: time.Unix(f.Time, 0),
+			Skipped: unchanged && (opts == nil || !opts.Force),
+		})
+	}
+
+	downloads := runDirJobs(ctx, opts.concurrency(d.downConcurrency), jobs, func(res *DirResult) {
+		if !res.Skipped {
+			res.Err = d.downloadDirFile(ctx, res)
+		}
+	})
+	for _, res := range downloads.Results {
+		report.add(res)
+	}
+	return report, report.err()
+}
+
+// downloadDirFile downloads into a .part file first. DownloadFile resumes a
+// file by its length, so the .part of an earlier run is only kept when the
+// size and modification time of the object recorded next to it still match.
+func (d *Downloader) downloadDirFile(ctx context.Context, res *DirResult) error {
+	if err := os.MkdirAll(filepath.Dir(res.Path), 0755); err != nil {
+		return err
+	}
+	part := res.Path + ".part"
+	if err := preparePart(part, res); err != nil {
+		return err
+	}
+	f, err := d.DownloadFileWithContext(ctx, res.Key, part)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err = os.Chtimes(part, time.Now(), res.ModTime); err != nil {
+		return err
+	}
+	if err = os.Rename(part, res.Path); err != nil {
+		return err
+	}
+	os.Remove(partMetaPath(part))
+	return nil
+}
+
+// partMeta is the version of the object a .part file holds bytes of.
+type partMeta struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+}
+
+func partMetaPath(part string) string {
+	return part + ".meta"
+}
+
+// preparePart removes a .part file left by a download of another version of
+// the object, or without a record of its version, and records the version
+// about to be downloaded.
+func preparePart(part string, res *DirResult) error {
+	want := partMeta{Size: res.Size, ModTime: res.ModTime.Unix()}
+	var got partMeta
+	if b, err := ioutil.ReadFile(partMetaPath(part)); err == nil && json.Unmarshal(b, &got) == nil && got == want {
+		return nil
+	}
+	if err := os.Remove(part); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(partMetaPath(part), b, 0644)
+}
@@ -0,0 +1,206 @@
+package operation_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// dirTree is the tree both directions work on, directories are the parents
+// of the files.
+var dirTree = []string{"top.txt", "a.log", "sub/b.txt", "sub/deep/c.txt", "skip/d.txt", "skip/inner/e.txt"}
+
+// remoteKeys lists the keys under prefix, relative to it.
+func remoteKeys(t *testing.T, c *operation.Client, prefix string) []string {
+	t.Helper()
+	files, err := c.ListObject(prefix, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, f := range files.Data {
+		keys = append(keys, strings.TrimSuffix(strings.TrimPrefix(f.Name, prefix), "/"))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// localPaths lists the files and directories under dir, relative to it.
+func localPaths(t *testing.T, dir string) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		paths = append(paths, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+var dirFilterTests = []struct {
+	name string
+	opts *operation.DirOptions
+	want []string
+}{
+	{"everything", nil, []string{"a.log", "skip", "skip/d.txt", "skip/inner", "skip/inner/e.txt", "sub", "sub/b.txt", "sub/deep", "sub/deep/c.txt", "top.txt"}},
+	{"include files", &operation.DirOptions{Include: []string{"*.txt"}}, []string{"skip", "skip/d.txt", "skip/inner", "skip/inner/e.txt", "sub", "sub/b.txt", "sub/deep", "sub/deep/c.txt", "top.txt"}},
+	{"exclude directory", &operation.DirOptions{Exclude: []string{"skip"}}, []string{"a.log", "sub", "sub/b.txt", "sub/deep", "sub/deep/c.txt", "top.txt"}},
+	{"exclude directory path", &operation.DirOptions{Exclude: []string{"sub/deep"}}, []string{"a.log", "skip", "skip/d.txt", "skip/inner", "skip/inner/e.txt", "sub", "sub/b.txt", "top.txt"}},
+}
+
+func TestUploadDir(t *testing.T) {
+	for _, tt := range dirFilterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := newTestClient(t, nil, nil)
+			dir := t.TempDir()
+			for _, rel := range dirTree {
+				local := filepath.Join(dir, filepath.FromSlash(rel))
+				if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(local, []byte(rel), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			report, err := c.UploadDir(dir, "dst", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := remoteKeys(t, c, "dst/"); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("uploaded %v, want %v", got, tt.want)
+			}
+			if report.Transferred != len(tt.want) || report.Skipped != 0 {
+				t.Errorf("first run: %d transferred, %d skipped", report.Transferred, report.Skipped)
+			}
+			report, err = c.UploadDir(dir, "dst", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Transferred != 0 || report.Skipped != len(tt.want) {
+				t.Errorf("second run: %d transferred, %d skipped", report.Transferred, report.Skipped)
+			}
+		})
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	for _, tt := range dirFilterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newTestClient(t, nil, nil)
+			for _, rel := range dirTree {
+				s.PutObject("bucket", "src/"+rel, []byte(rel))
+			}
+			if err := c.UploadFloder(nil, "src/skip/inner", true); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if _, err := c.DownloadDir("src", dir, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := localPaths(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("downloaded %v, want %v", got, tt.want)
+			}
+			report, err := c.DownloadDir("src", dir, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Transferred != 0 {
+				t.Errorf("second run transferred %d", report.Transferred)
+			}
+		})
+	}
+}
+
+// The .part file of an interrupted run is continued only when it holds
+// bytes of the same version of the object.
+func TestDownloadDirPartFile(t *testing.T) {
+	data := randomBytes(10000)
+	tests := []struct {
+		name   string
+		part   []byte
+		meta   func(mtime int64) string
+		resume bool
+	}{
+		{"same version", data[:4000], func(mtime int64) string {
+			return `{"size":10000,"mtime":` + strconv.FormatInt(mtime, 10) + `}`
+		}, true},
+		{"other version", bytes.Repeat([]byte("x"), 4000), func(mtime int64) string {
+			return `{"size":10000,"mtime":` + strconv.FormatInt(mtime-10, 10) + `}`
+		}, false},
+		{"no record", bytes.Repeat([]byte("x"), 4000), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "src/obj", data)
+			var ranged int32
+			front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					atomic.StoreInt32(&ranged, 1)
+				}
+				s.ServeHTTP(w, r)
+			}))
+			defer front.Close()
+			c, err := operation.NewClient(&operation.Config{IoHosts: []string{strings.TrimPrefix(front.URL, "http://")}, Bucket: "bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			meta, err := c.MetaInfo("src/obj")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			local := filepath.Join(dir, "obj")
+			if err = ioutil.WriteFile(local+".part", tt.part, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.meta != nil {
+				if err = ioutil.WriteFile(local+".part.meta", []byte(tt.meta(meta.Time)), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err = c.DownloadDir("src", dir, nil); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(local)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("%d bytes, %v", len(got), err)
+			}
+			if resumed := atomic.LoadInt32(&ranged) == 1; resumed != tt.resume {
+				t.Errorf("resumed %v, want %v", resumed, tt.resume)
+			}
+			if paths := localPaths(t, dir); !reflect.DeepEqual(paths, []string{"obj"}) {
+				t.Errorf("left %v", paths)
+			}
+			info, err := os.Stat(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(time.Unix(meta.Time, 0)) {
+				t.Errorf("mtime %v, want %v", info.ModTime(), time.Unix(meta.Time, 0))
+			}
+		})
+	}
+}
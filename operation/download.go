@@ -1,46 +1,33 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/qiniupd/qiniu-go-sdk/x/rpc.v7"
 )
 
-var downloadClient = &http.Client{
-	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   1 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	},
-	Timeout: 10 * time.Minute,
-}
-
 type Downloader struct {
-	bucket  string
-	ioHosts []string
-	queryer *Queryer
+	bucket string
+	*clientBase
+	limiter         *RateLimiter
+	partSize        int64
+	downConcurrency int
+	cache           *BlockCache
 }
 
 type wrapper struct {
 	s    io.ReadCloser
+	r    io.Reader
 	host string
 }
 
@@ -49,6 +36,7 @@ type ExternHeaders struct {
 	Connection  []string `json:"Connection"`
 	ContentType []string `json:"Content-Type"`
 	Floder      []string `json:"Floder"`
+	Checksum    []string `json:"Checksum"`
 }
 
 type Res struct {
@@ -57,7 +45,7 @@ type Res struct {
 }
 
 func (w *wrapper) Read(p []byte) (n int, err error) {
-	n, err = w.s.Read(p)
+	n, err = w.r.Read(p)
 	if err != nil && err != io.EOF {
 		elog.Info("read interrupt", w.host, err)
 	}
@@ -70,16 +58,17 @@ func (w *wrapper) Close() error {
 
 func NewDownloader(c *Config) *Downloader {
 
-	var queryer *Queryer = nil
-
 	downloader := Downloader{
-		bucket:  c.Bucket,
-		ioHosts: dupStrings(c.IoHosts),
-		queryer: queryer,
+		bucket:          c.Bucket,
+		clientBase:      newClientBase(c),
+		limiter:         NewRateLimiter(c.DownRateLimit),
+		partSize:        c.PartSize,
+		downConcurrency: c.DownConcurrency,
+		cache:           NewBlockCache(c),
 	}
-	shuffleHosts(downloader.ioHosts)
 	return &downloader
 }
+
 func NewDownloaderV2() *Downloader {
 	c := getConf()
 	if c == nil {
@@ -89,54 +78,73 @@ func NewDownloaderV2() *Downloader {
 }
 
 func (d *Downloader) DownloadFile(key, path string) (f *os.File, err error) {
-	for i := 0; i < 3; i++ {
-		f, err = d.downloadFileInner(key, path)
-		if err == nil {
-			return
-		}
-	}
+	return d.DownloadFileWithContext(context.Background(), key, path)
+}
+
+func (d *Downloader) DownloadFileWithContext(ctx context.Context, key, path string) (f *os.File, err error) {
+	ctx = d.trackProgress(ctx, OpDownload, key, -1)
+	err = d.retryPolicy().do(ctx, d.logger(), OpDownload, func() (err error) {
+		f, err = d.downloadFileInner(ctx, key, path)
+		return
+	})
 	return
 }
 
 func (d *Downloader) DownloadBytes(key string) (data []byte, err error) {
-	for i := 0; i < 3; i++ {
-		data, err = d.downloadBytesInner(key)
-		if err == nil {
-			break
-		}
-	}
+	return d.DownloadBytesWithContext(context.Background(), key)
+}
+
+func (d *Downloader) DownloadBytesWithContext(ctx context.Context, key string) (data []byte, err error) {
+	ctx = d.trackProgress(ctx, OpDownload, key, -1)
+	err = d.retryPolicy().do(ctx, d.logger(), OpDownload, func() (err error) {
+		data, err = d.downloadBytesInner(ctx, key)
+		return
+	})
 	return
 }
 
 func (d *Downloader) DownloadRangeBytes(key string, offset, size int64) (l int64, data []byte, err error) {
-	for i := 0; i < 3; i++ {
-		l, data, err = d.downloadRangeBytesInner(key, offset, size)
-		if err == nil {
-			break
-		}
+	return d.DownloadRangeBytesWithContext(context.Background(), key, offset, size)
+}
+
+func (d *Downloader) DownloadRangeBytesWithContext(ctx context.Context, key string, offset, size int64) (l int64, data []byte, err error) {
+	ctx = d.trackProgress(ctx, OpDownload, key, size)
+	if d.cache != nil && offset >= 0 && size > 0 {
+		return d.readRange(ctx, key, offset, size)
 	}
+	err = d.retryPolicy().do(ctx, d.logger(), OpDownload, func() (err error) {
+		l, data, err = d.downloadRangeBytesInner(ctx, key, offset, size)
+		return
+	})
 	return
 }
 
 func (d *Downloader) DownloadRangeReader(key string, offset, size int64) (l int64, reader io.ReadCloser, err error) {
+	return d.DownloadRangeReaderWithContext(context.Background(), key, offset, size)
+}
+
+// DownloadRangeReaderWithContext returns a reader over the range, ctx must
+// stay alive until the reader is closed.
+func (d *Downloader) DownloadRangeReaderWithContext(ctx context.Context, key string, offset, size int64) (l int64, reader io.ReadCloser, err error) {
+	ctx = d.trackProgress(ctx, OpDownload, key, size)
 	failedIoHosts := make(map[string]struct{})
-	for i := 0; i < 3; i++ {
-		l, reader, err = d.downloadRangeReaderInner(key, offset, size, failedIoHosts)
-		if err == nil {
-			break
-		}
-	}
+	err = d.retryPolicy().do(ctx, d.logger(), OpDownload, func() (err error) {
+		l, reader, err = d.downloadRangeReaderInner(ctx, key, offset, size, failedIoHosts)
+		return
+	})
 	return
 }
 
 func (d *Downloader) DownloadRaw(key string, headers http.Header) (resp *http.Response, err error) {
+	return d.DownloadRawWithContext(context.Background(), key, headers)
+}
+
+func (d *Downloader) DownloadRawWithContext(ctx context.Context, key string, headers http.Header) (resp *http.Response, err error) {
 	failedIoHosts := make(map[string]struct{})
-	for i := 0; i < 3; i++ {
-		resp, _, err = d.downloadRawInner(key, headers, failedIoHosts)
-		if err == nil {
-			return
-		}
-	}
+	err = d.retryPolicy().do(ctx, d.logger(), OpDownload, func() (err error) {
+		resp, _, err = d.downloadRawInner(ctx, key, headers, failedIoHosts)
+		return
+	})
 	return
 }
 
@@ -150,35 +158,7 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-var curIoHostIndex uint32 = 0
-
-func (d *Downloader) nextHost() string {
-	ioHosts := d.ioHosts
-	if d.queryer != nil {
-		if hosts := d.queryer.QueryIoHosts(false); len(hosts) > 0 {
-			shuffleHosts(hosts)
-			ioHosts = hosts
-		}
-	}
-	switch len(ioHosts) {
-	case 0:
-		panic("No Io hosts is configured")
-	case 1:
-		return ioHosts[0]
-	default:
-		var ioHost string
-		for i := 0; i <= len(ioHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curIoHostIndex, 1) - 1)
-			ioHost = ioHosts[index%len(ioHosts)]
-			if isHostNameValid(ioHost) {
-				break
-			}
-		}
-		return ioHost
-	}
-}
-
-func (d *Downloader) downloadFileInner(key, path string) (*os.File, error) {
+func (d *Downloader) downloadFileInner(ctx context.Context, key, path string) (*os.File, error) {
 	//if strings.HasPrefix(key, "/") {
 	//	key = strings.TrimPrefix(key, "/")
 	//}
@@ -190,13 +170,14 @@ func (d *Downloader) downloadFileInner(key, path string) (*os.File, error) {
 	if err != nil {
 		return nil, err
 	}
-	host := d.nextHost()
+	host := d.nextHost(ctx)
+	start := time.Now()
 
-	fmt.Println("remote path", key)
-	url := fmt.Sprintf("http://%s/objects/getfile/%s/%s", host, d.bucket, key)
-	req, err := http.NewRequest("GET", url, nil)
+	d.logger().Debug("download file", d.bucket, key)
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return nil, err
 	}
 	req.Header.Set("Accept-Encoding", "")
@@ -204,94 +185,131 @@ func (d *Downloader) downloadFileInner(key, path string) (*os.File, error) {
 	if length != 0 {
 		r := fmt.Sprintf("bytes=%d-", length)
 		req.Header.Set("Range", r)
-		fmt.Println("continue download")
+		d.logger().Debug("continue download", d.bucket, key, length)
 	}
 
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpDownload, host, d.bucket, key, req)
 	if err != nil {
-		failHostName(host)
-		return nil, err
+		d.failHost(host)
+		return nil, hostError(OpDownload, host, d.bucket, key, err)
 	}
 	defer response.Body.Close()
-	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
-		succeedHostName(host)
-		return f, nil
-	}
-	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
-		failHostName(host)
-		return nil, errors.New(response.Status)
-	}
-	succeedHostName(host)
-	ctLength := response.ContentLength
-	n, err := io.Copy(f, response.Body)
-	if err != nil {
-		return nil, err
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent &&
+		response.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		d.answered(host, start, response.StatusCode)
+		return nil, responseError(OpDownload, host, d.bucket, key, response, nil)
+	}
+	d.succeedHost(host, start)
+
+	// the part downloaded by an earlier attempt is hashed first
+	var w io.Writer = f
+	verifier := newChecksumVerifier(response.Header.Get(checksumHeader))
+	if verifier != nil {
+		if _, err = io.Copy(verifier, io.NewSectionReader(f, 0, length)); err != nil {
+			return nil, err
+		}
+		w = io.MultiWriter(f, verifier)
+	}
+	if response.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		ctLength := response.ContentLength
+		if response.StatusCode == http.StatusPartialContent {
+			progressTotal(ctx, length+ctLength)
+		} else {
+			progressTotal(ctx, ctLength)
+		}
+		n, err := io.Copy(w, progressReader(ctx, d.limitReader(ctx, host, response.Body), 0, length))
+		if err != nil {
+			return nil, err
+		}
+		if ctLength != n {
+			d.logger().Warn("download length not equal", ctLength, n)
+		}
 	}
-	if ctLength != n {
-		elog.Warn("download length not equal", ctLength, n)
+	if verifier != nil {
+		if err = verifier.verify(OpDownload, host, d.bucket, key); err != nil {
+			// start over on retry instead of resuming after corrupted data
+			f.Truncate(0)
+			f.Close()
+			return nil, err
+		}
 	}
 	f.Seek(0, io.SeekStart)
 	return f, nil
 }
 
-func (d *Downloader) downloadBytesInner(key string) ([]byte, error) {
+func (d *Downloader) downloadBytesInner(ctx context.Context, key string) ([]byte, error) {
 	//if strings.HasPrefix(key, "/") {
 	//	key = strings.TrimPrefix(key, "/")
 	//}
-	host := d.nextHost()
+	host := d.nextHost(ctx)
+	start := time.Now()
 
-	url := fmt.Sprintf("http://%s/objects/getfile/%s/%s", host, d.bucket, key)
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", rpc.UserAgent)
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpDownload, host, d.bucket, key, req)
 	if err != nil {
-		failHostName(host)
-		return nil, err
+		d.failHost(host)
+		return nil, hostError(OpDownload, host, d.bucket, key, err)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return nil, errors.New(response.Status)
+		d.answered(host, start, response.StatusCode)
+		return nil, responseError(OpDownload, host, d.bucket, key, response, nil)
+	}
+	d.succeedHost(host, start)
+	progressTotal(ctx, response.ContentLength)
+	data, err := ioutil.ReadAll(progressReader(ctx, d.limitReader(ctx, host, response.Body), 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	if verifier := newChecksumVerifier(response.Header.Get(checksumHeader)); verifier != nil {
+		verifier.Write(data)
+		if err = verifier.verify(OpDownload, host, d.bucket, key); err != nil {
+			return nil, err
+		}
 	}
-	succeedHostName(host)
-	return ioutil.ReadAll(response.Body)
+	return data, nil
 }
 
-func (d *Downloader) downloadRangeReaderInner(key string, offset, size int64, failedIoHosts map[string]struct{}) (int64, io.ReadCloser, error) {
+// downloadRangeReaderInner hands the body over to the returned reader, it is
+// only closed here on failure. failedIoHosts holds the hosts of the earlier
+// attempts, so a retry goes to another host.
+func (d *Downloader) downloadRangeReaderInner(ctx context.Context, key string, offset, size int64, failedIoHosts map[string]struct{}) (int64, io.ReadCloser, error) {
 	headers := make(http.Header)
 	headers.Set("Range", generateRange(offset, size))
-	host := d.nextHost()
+	host := d.nextHostExcept(ctx, failedIoHosts)
+	start := time.Now()
 
-	url := fmt.Sprintf("%s/objects/getfile/%s/%s", host, d.bucket, key)
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return -1, nil, err
 	}
 	req.Header.Set("Range", generateRange(offset, size))
 	req.Header.Set("User-Agent", rpc.UserAgent)
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpDownload, host, d.bucket, key, req)
 	if err != nil {
-		failHostName(host)
-		return -1, nil, err
+		d.failHost(host)
+		return -1, nil, hostError(OpDownload, host, d.bucket, key, err)
 	}
-	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusPartialContent {
 		failedIoHosts[host] = struct{}{}
-		failHostName(host)
+		d.answered(host, start, response.StatusCode)
 		response.Body.Close()
-		return -1, nil, errors.New(response.Status)
+		return -1, nil, responseError(OpDownload, host, d.bucket, key, response, nil)
 	}
 
 	rangeResponse := response.Header.Get("Content-Range")
 	if rangeResponse == "" {
 		failedIoHosts[host] = struct{}{}
-		failHostName(host)
+		d.failHost(host)
 		response.Body.Close()
 		return -1, nil, errors.New("no content range")
 	}
@@ -299,35 +317,39 @@ func (d *Downloader) downloadRangeReaderInner(key string, offset, size int64, fa
 	l, err := getTotalLength(rangeResponse)
 	if err != nil {
 		failedIoHosts[host] = struct{}{}
-		failHostName(host)
+		d.failHost(host)
 		response.Body.Close()
 		return -1, nil, err
 	}
+	d.succeedHost(host, start)
 	w := wrapper{
 		s:    response.Body,
+		r:    progressReader(ctx, d.limitReader(ctx, host, response.Body), 0, 0),
 		host: host,
 	}
 	return l, &w, err
 }
 
-func (d *Downloader) downloadRawInner(key string, headers http.Header, failedIoHosts map[string]struct{}) (*http.Response, string, error) {
-	host := d.nextHost()
+// downloadRawInner retries on a host not in failedIoHosts, like
+// downloadRangeReaderInner.
+func (d *Downloader) downloadRawInner(ctx context.Context, key string, headers http.Header, failedIoHosts map[string]struct{}) (*http.Response, string, error) {
+	host := d.nextHostExcept(ctx, failedIoHosts)
 
-	url := fmt.Sprintf("%s/objects/getfile/%s/%s", host, d.bucket, key)
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		failedIoHosts[host] = struct{}{}
-		failHostName(host)
+		d.failHost(host)
 		return nil, host, err
 	}
 	for headerName, headerValue := range headers {
 		req.Header[headerName] = headerValue
 	}
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpDownload, host, d.bucket, key, req)
 	if err != nil {
 		failedIoHosts[host] = struct{}{}
-		failHostName(host)
-		return nil, host, err
+		d.failHost(host)
+		return nil, host, hostError(OpDownload, host, d.bucket, key, err)
 	}
 	return response, host, nil
 }
@@ -339,49 +361,50 @@ func generateRange(offset, size int64) string {
 	return fmt.Sprintf("bytes=%d-%d", offset, offset+size)
 }
 
-func (d *Downloader) downloadRangeBytesInner(key string, offset, size int64) (int64, []byte, error) {
+func (d *Downloader) downloadRangeBytesInner(ctx context.Context, key string, offset, size int64) (int64, []byte, error) {
 	if strings.HasPrefix(key, "/") {
 		key = strings.TrimPrefix(key, "/")
 	}
-	host := d.nextHost()
+	host := d.nextHost(ctx)
+	start := time.Now()
 
-	url := fmt.Sprintf("%s/objects/getfile/%s/%s", host, d.bucket, key)
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return -1, nil, err
 	}
 
 	req.Header.Set("Range", generateRange(offset, size))
 	req.Header.Set("User-Agent", rpc.UserAgent)
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpDownload, host, d.bucket, key, req)
 	if err != nil {
-		failHostName(host)
-		return -1, nil, err
+		d.failHost(host)
+		return -1, nil, hostError(OpDownload, host, d.bucket, key, err)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusPartialContent {
-		failHostName(host)
-		return -1, nil, errors.New(response.Status)
+		d.answered(host, start, response.StatusCode)
+		return -1, nil, responseError(OpDownload, host, d.bucket, key, response, nil)
 	}
 
 	rangeResponse := response.Header.Get("Content-Range")
 	if rangeResponse == "" {
-		failHostName(host)
+		d.failHost(host)
 		return -1, nil, errors.New("no content range")
 	}
 
 	l, err := getTotalLength(rangeResponse)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return -1, nil, err
 	}
-	b, err := ioutil.ReadAll(response.Body)
+	b, err := ioutil.ReadAll(progressReader(ctx, d.limitReader(ctx, host, response.Body), 0, 0))
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 	} else {
-		succeedHostName(host)
+		d.succeedHost(host, start)
 	}
 	return l, b, err
 }
@@ -395,86 +418,97 @@ func getTotalLength(crange string) (int64, error) {
 	return strconv.ParseInt(cr[1], 10, 64)
 }
 
-func (d *Downloader) getFileExietInner(fileName string) (string, error) {
-	host := d.nextHost()
-	//elog.Infof("Get File Exiet %s \n", d.bucket)
-	url := fmt.Sprintf("http://%s/objects/getfile/%s/%s", host, d.bucket, fileName)
-	req, err := http.NewRequest("HEAD", url, nil)
+func (d *Downloader) getFileExietInner(ctx context.Context, fileName string) error {
+	host := d.nextHost(ctx)
+	start := time.Now()
+	//d.logger().Infof("Get File Exiet %s \n", d.bucket)
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, fileName)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
-		failHostName(host)
-		return err.Error(), err
+		d.failHost(host)
+		return err
 	}
 	req.Header.Set("Accept-Encoding", "")
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpStat, host, d.bucket, fileName, req)
 	if err != nil {
-		failHostName(host)
-		return err.Error(), err
+		d.failHost(host)
+		return hostError(OpStat, host, d.bucket, fileName, err)
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
-		failHostName(host)
-		return err.Error(), errors.New(response.Status)
+	if response.StatusCode != http.StatusOK {
+		d.answered(host, start, response.StatusCode)
+		return responseError(OpStat, host, d.bucket, fileName, response, nil)
 	}
-	succeedHostName(host)
-	return response.Status, nil
+	d.succeedHost(host, start)
+	return nil
 }
 
-func (d *Downloader) getFileSizeInner(fileName string) (int64, error) {
-	host := d.nextHost()
-	url := fmt.Sprintf("http://%s/objects/metadetail", host)
-	req, err := http.NewRequest("GET", url, nil)
+func (d *Downloader) getFileMetaInner(ctx context.Context, fileName string) (*Res, error) {
+	host := d.nextHost(ctx)
+	start := time.Now()
+	url := fmt.Sprintf("%s/objects/metadetail", d.hostUrl(host))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
-		return -1, err
+		d.failHost(host)
+		return nil, err
 	}
 	req.Header.Set("object", fileName)
 	req.Header.Set("bucket", d.bucket)
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpMetaInfo, host, d.bucket, fileName, req)
 	if err != nil {
-		failHostName(host)
-		return -1, err
+		d.failHost(host)
+		return nil, hostError(OpMetaInfo, host, d.bucket, fileName, err)
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
-		failHostName(host)
-		return -1, errors.New(response.Status)
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		d.failHost(host)
+		return nil, hostError(OpMetaInfo, host, d.bucket, fileName, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		d.answered(host, start, response.StatusCode)
+		return nil, responseError(OpMetaInfo, host, d.bucket, fileName, response, b)
 	}
 
-	b, err := ioutil.ReadAll(response.Body)
 	res := &Res{}
 	err = json.Unmarshal(b, res)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
-	succeedHostName(host)
-	return res.Size, nil
+	d.succeedHost(host, start)
+	return res, nil
 
 }
 
 func (d *Downloader) GetFileExiet(fileName string) (bool, error) {
-	var err error
-	for i := 0; i < 3; i++ {
-		res, err := d.getFileExietInner(fileName)
-		if err == nil {
-			if find := strings.Contains(res, "200 OK"); find {
-				return true, nil
-			} else {
-				return false, errors.New(res)
-			}
-		}
-	}
-	return false, err
+	return d.GetFileExietWithContext(context.Background(), fileName)
+}
+
+func (d *Downloader) GetFileExietWithContext(ctx context.Context, fileName string) (bool, error) {
+	err := d.retryPolicy().do(ctx, d.logger(), OpStat, func() error {
+		return d.getFileExietInner(ctx, fileName)
+	})
+	return err == nil, err
 }
 
 func (d *Downloader) GetFileSize(fileName string) (int64, error) {
-	var err error
-	for i := 0; i < 3; i++ {
-		res, err := d.getFileSizeInner(fileName)
-		if err == nil {
-			return res, nil
-		}
+	return d.GetFileSizeWithContext(context.Background(), fileName)
+}
+
+func (d *Downloader) GetFileSizeWithContext(ctx context.Context, fileName string) (int64, error) {
+	res, err := d.getFileMeta(ctx, fileName)
+	if err != nil {
+		return -1, err
 	}
-	return -1, err
+	return res.Size, nil
+}
+
+func (d *Downloader) getFileMeta(ctx context.Context, fileName string) (res *Res, err error) {
+	err = d.retryPolicy().do(ctx, d.logger(), OpMetaInfo, func() (err error) {
+		res, err = d.getFileMetaInner(ctx, fileName)
+		return
+	})
+	return
 }
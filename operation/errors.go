@@ -0,0 +1,125 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Operation names the kind of request an error or a retry decision is
+// about.
+type Operation string
+
+const (
+	OpUpload            Operation = "upload"
+	OpInitMultipart     Operation = "initmultipart"
+	OpUploadPart        Operation = "putpart"
+	OpCompleteMultipart Operation = "completemultipart"
+	OpAbortMultipart    Operation = "abortmultipart"
+	OpDownload          Operation = "download"
+	OpStat              Operation = "stat"
+	OpMetaInfo          Operation = "metainfo"
+	OpDelete            Operation = "delete"
+	OpRename            Operation = "rename"
+	OpCopy              Operation = "copy"
+	OpListObject        Operation = "listobject"
+	OpMakeBucket        Operation = "makebucket"
+	OpDeleteBucket      Operation = "deletebucket"
+	OpListBucket        Operation = "listbucket"
+	OpGetBucket         Operation = "getbucket"
+)
+
+// Sentinel errors, match them with errors.Is.
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrAlreadyExists       = errors.New("already exists")
+	ErrBucketNotEmpty      = errors.New("bucket not empty")
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+	ErrHostUnavailable     = errors.New("host unavailable")
+	ErrChecksumMismatch    = errors.New("checksum mismatch")
+	ErrAccessDenied        = errors.New("access denied")
+)
+
+// Error is returned for every failed request, Kind is one of the sentinel
+// errors above or nil when the failure is not classified. Use errors.As to
+// get at the status, host, bucket and key.
+type Error struct {
+	Op         Operation
+	StatusCode int
+	Host       string
+	Bucket     string
+	Key        string
+	Message    string
+	Kind       error
+	Err        error
+}
+
+func (e *Error) Error() string {
+	target := e.Bucket
+	if e.Key != "" {
+		target += "/" + e.Key
+	}
+	msg := e.Message
+	if msg == "" && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.Host != "" {
+		target += " on " + e.Host
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s %s: %d %s", e.Op, target, e.StatusCode, msg)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Op, target, msg)
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// hostError wraps a transport error, anything but a canceled context means
+// the host could not be reached.
+func hostError(op Operation, host, bucket, key string, err error) error {
+	e := &Error{Op: op, Host: host, Bucket: bucket, Key: key, Err: err}
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		e.Kind = ErrHostUnavailable
+	}
+	return e
+}
+
+// responseError classifies a non successful response, body is what was read
+// from it and may be nil.
+func responseError(op Operation, host, bucket, key string, resp *http.Response, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	e := &Error{Op: op, StatusCode: resp.StatusCode, Host: host, Bucket: bucket, Key: key, Message: msg}
+	lower := strings.ToLower(msg)
+	// the server answers several conflicts with 409, the message tells them
+	// apart and the bare status only classifies what it does not
+	switch {
+	case strings.Contains(lower, "already exist"):
+		e.Kind = ErrAlreadyExists
+	case strings.Contains(lower, "not empty"):
+		e.Kind = ErrBucketNotEmpty
+	case strings.Contains(lower, "checksum mismatch"):
+		e.Kind = ErrChecksumMismatch
+	case resp.StatusCode == http.StatusNotFound:
+		e.Kind = ErrNotFound
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.Kind = ErrAccessDenied
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		e.Kind = ErrRangeNotSatisfiable
+	case resp.StatusCode == http.StatusConflict:
+		e.Kind = ErrAlreadyExists
+	case resp.StatusCode >= http.StatusInternalServerError:
+		e.Kind = ErrHostUnavailable
+	}
+	return e
+}
@@ -0,0 +1,93 @@
+package operation_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestErrorKinds(t *testing.T) {
+	s := bsttest.NewServer("bucket", "full")
+	defer s.Close()
+	s.PutObject("bucket", "obj", []byte("0123456789"))
+	s.PutObject("full", "obj", []byte("data"))
+	cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1}
+	d := operation.NewDownloader(cfg)
+	defer d.Close()
+	m := operation.NewModifier(cfg)
+	defer m.Close()
+	p := operation.NewUploader(cfg)
+	defer p.Close()
+	b := operation.NewBucketer(cfg)
+	defer b.Close()
+
+	closed := bsttest.NewServer("bucket")
+	closed.Close()
+	gone := operation.NewDownloader(&operation.Config{IoHosts: []string{closed.Host()}, Bucket: "bucket", Retry: 1})
+	defer gone.Close()
+
+	// a server that answers a conflict other than an existing object with 409
+	conflict := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Bucket not empty cannot delete", http.StatusConflict)
+	}))
+	defer conflict.Close()
+	cb := operation.NewBucketer(&operation.Config{IoHosts: []string{strings.TrimPrefix(conflict.URL, "http://")}, Retry: 1})
+	defer cb.Close()
+
+	tests := []struct {
+		name   string
+		call   func() error
+		kind   error
+		op     operation.Operation
+		status int
+	}{
+		{"missing object", func() error {
+			_, err := d.DownloadBytes("missing")
+			return err
+		}, operation.ErrNotFound, operation.OpDownload, 404},
+		{"missing meta", func() error {
+			_, err := m.MetaInfo("missing")
+			return err
+		}, operation.ErrNotFound, operation.OpMetaInfo, 404},
+		{"existing object", func() error {
+			return p.UploadBytes([]byte("new"), "obj", false, false)
+		}, operation.ErrAlreadyExists, operation.OpUpload, 409},
+		{"existing bucket", func() error {
+			return b.MakeBucket("bucket")
+		}, operation.ErrAlreadyExists, operation.OpMakeBucket, 409},
+		{"bucket not empty", func() error {
+			return b.DeleteBucket("full")
+		}, operation.ErrBucketNotEmpty, operation.OpDeleteBucket, 400},
+		{"bucket not empty as a conflict", func() error {
+			return cb.DeleteBucket("full")
+		}, operation.ErrBucketNotEmpty, operation.OpDeleteBucket, 409},
+		{"range past the end", func() error {
+			_, _, err := d.DownloadRangeBytes("obj", 100, 5)
+			return err
+		}, operation.ErrRangeNotSatisfiable, operation.OpDownload, 416},
+		{"host down", func() error {
+			_, err := gone.DownloadBytes("obj")
+			return err
+		}, operation.ErrHostUnavailable, operation.OpDownload, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("err = %v, want %v", err, tt.kind)
+			}
+			var e *operation.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %T, want *operation.Error", err)
+			}
+			if e.Op != tt.op || e.StatusCode != tt.status {
+				t.Errorf("op %s status %d, want %s %d", e.Op, e.StatusCode, tt.op, tt.status)
+			}
+		})
+	}
+}
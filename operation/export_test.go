@@ -0,0 +1,17 @@
+package operation
+
+import "io/ioutil"
+
+// The uc host cache of the tests must neither use nor overwrite the one of
+// the user, the init of query.go has loaded it already.
+func init() {
+	dir, err := ioutil.TempDir("", "bst-query-cache")
+	if err != nil {
+		panic(err)
+	}
+	cacheDirectory = dir
+	cacheMap.Range(func(key, _ interface{}) bool {
+		cacheMap.Delete(key)
+		return true
+	})
+}
@@ -0,0 +1,281 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS presents a bucket as a read only file system. Object names are paths
+// separated by "/", objects uploaded with UploadFloder are directories and so
+// is every prefix that other objects are stored under. Files are Objects, so
+// they can be read, seeked and read at any offset.
+type FS struct {
+	d   *Downloader
+	m   *Modify
+	ctx context.Context
+}
+
+var (
+	_ fs.FS          = (*FS)(nil)
+	_ fs.StatFS      = (*FS)(nil)
+	_ fs.ReadDirFS   = (*FS)(nil)
+	_ fs.File        = (*fsFile)(nil)
+	_ io.ReadSeeker  = (*fsFile)(nil)
+	_ fs.ReadDirFile = (*fsDir)(nil)
+)
+
+// NewFS reads objects with d and looks them up with m, both must use the
+// same bucket.
+func NewFS(d *Downloader, m *Modify) *FS {
+	return &FS{d: d, m: m, ctx: context.Background()}
+}
+
+func (c *Client) FS() *FS {
+	return NewFS(c.Downloader, c.Modify)
+}
+
+// WithContext returns a copy of f whose requests, including the reads of
+// opened files, are done with ctx.
+func (f *FS) WithContext(ctx context.Context) *FS {
+	return &FS{d: f.d, m: f.m, ctx: ctx}
+}
+
+// HTTP adapts f for http.FileServer.
+func (f *FS) HTTP() http.FileSystem {
+	return http.FS(f)
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &fsDir{f: f, info: info}, nil
+	}
+	return &fsFile{Object: f.d.newObject(f.ctx, info.key, info.size), info: info}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (f *FS) stat(op, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fileInfo{name: ".", dir: true}, nil
+	}
+	meta, err := f.m.MetaInfoWithContext(f.ctx, name)
+	if err == nil {
+		return &fileInfo{
+			name:  path.Base(name),
+			key:   name,
+			size:  meta.Size,
+			mtime: meta.Time,
+			dir:   meta.Dir,
+			sys:   meta,
+		}, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	// no object of that name, it is still a directory when objects are
+	// stored under it
+	files, err := f.m.listObjInner(f.ctx, name+"/", 1, 1)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if files.Len == 0 && len(files.Data) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(name), key: name, dir: true}, nil
+}
+
+// ReadDir lists the bucket under name, entries are sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := f.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// readDir walks the listing with "/" as the delimiter, so the objects
+// deeper down come folded into one entry per subdirectory.
+func (f *FS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	it := f.m.ObjectsWithContext(f.ctx, &ListOptions{Prefix: prefix, Delimiter: "/"})
+	children := make(map[string]*fileInfo)
+	for it.Next() {
+		entry := it.Entry()
+		rest := strings.TrimSuffix(strings.TrimPrefix(entry.Name, prefix), "/")
+		if rest == "" {
+			continue
+		}
+		// a folder object comes before the prefix of the objects under it
+		if _, ok := children[rest]; ok {
+			continue
+		}
+		if entry.CommonPrefix {
+			children[rest] = &fileInfo{name: rest, key: prefix + rest, dir: true}
+			continue
+		}
+		file := entry.BstFile
+		children[rest] = &fileInfo{
+			name:  rest,
+			key:   file.Name,
+			size:  file.Size,
+			mtime: file.Time,
+			dir:   file.Dir,
+			sys:   &file,
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, info := range children {
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// fileInfo is both the fs.FileInfo and the fs.DirEntry of an object. Sys
+// returns the *MetaInfo or *BstFile it was built from, nil for directories
+// that only exist as a prefix.
+type fileInfo struct {
+	name  string
+	key   string
+	size  int64
+	mtime int64
+	dir   bool
+	sys   interface{}
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
+
+func (i *fileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	return time.Unix(i.mtime, 0)
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.dir
+}
+
+func (i *fileInfo) Sys() interface{} {
+	return i.sys
+}
+
+func (i *fileInfo) Type() fs.FileMode {
+	return i.Mode().Type()
+}
+
+func (i *fileInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
+
+type fsFile struct {
+	*Object
+	info *fileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// fsDir lists its entries on the first ReadDir.
+type fsDir struct {
+	f       *FS
+	info    *fileInfo
+	entries []fs.DirEntry
+	loaded  bool
+	off     int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.key, Err: errors.New("is a directory")}
+}
+
+// Seek only rewinds the listing, http.FileServer seeks directories to the
+// start before listing them.
+func (d *fsDir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, &fs.PathError{Op: "seek", Path: d.info.key, Err: fs.ErrInvalid}
+	}
+	d.off = 0
+	return 0, nil
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		name := d.info.key
+		if name == "" {
+			name = "."
+		}
+		entries, err := d.f.readDir(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		d.entries, d.loaded = entries, true
+	}
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+	return rest[:n], nil
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
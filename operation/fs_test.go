@@ -0,0 +1,121 @@
+package operation_test
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// fsTree is a small tree: files at the top, a folder object with and one
+// without children, and directories that only exist as a prefix.
+var fsTree = map[string]string{
+	"empty/":       "",
+	"folder/":      "",
+	"top.txt":      "content of top.txt",
+	"folder/a.txt": "content of folder/a.txt",
+	"a/b/c.txt":    "content of a/b/c.txt",
+	"a/b/d.txt":    "content of a/b/d.txt",
+	"a/e.txt":      "content of a/e.txt",
+	"a-b.txt":      "content of a-b.txt",
+}
+
+func TestFSConformance(t *testing.T) {
+	_, c := newTestClient(t, fsTree, nil)
+	if err := fstest.TestFS(c.FS(), "top.txt", "folder/a.txt", "a/b/c.txt", "a/b/d.txt", "a/e.txt", "a-b.txt", "empty"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSStat(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		dir  bool
+		size int64
+		err  error
+	}{
+		{"root", ".", true, 0, nil},
+		{"file", "a/e.txt", false, int64(len("content of a/e.txt")), nil},
+		{"folder object", "empty", true, 0, nil},
+		{"prefix", "a/b", true, 0, nil},
+		{"missing", "a/missing", false, 0, fs.ErrNotExist},
+		{"invalid", "/a", false, 0, fs.ErrInvalid},
+	}
+	_, c := newTestClient(t, fsTree, nil)
+	fsys := c.FS()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := fs.Stat(fsys, tt.path)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.IsDir() != tt.dir || (!tt.dir && info.Size() != tt.size) {
+				t.Errorf("dir %v, size %d", info.IsDir(), info.Size())
+			}
+		})
+	}
+}
+
+// A directory lists each child once, the objects deeper down are folded
+// into their subdirectory.
+func TestFSReadDir(t *testing.T) {
+	tests := []struct {
+		dir   string
+		names []string
+	}{
+		{".", []string{"a", "a-b.txt", "empty", "folder", "top.txt"}},
+		{"a", []string{"b", "e.txt"}},
+		{"a/b", []string{"c.txt", "d.txt"}},
+		{"folder", []string{"a.txt"}},
+		{"empty", nil},
+	}
+	_, c := newTestClient(t, fsTree, nil)
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			entries, err := fs.ReadDir(c.FS(), tt.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			if len(names) != len(tt.names) {
+				t.Fatalf("entries %v, want %v", names, tt.names)
+			}
+			for i := range names {
+				if names[i] != tt.names[i] {
+					t.Fatalf("entries %v, want %v", names, tt.names)
+				}
+			}
+		})
+	}
+}
+
+func TestFSHTTP(t *testing.T) {
+	_, c := newTestClient(t, fsTree, nil)
+	server := httptest.NewServer(http.FileServer(c.FS().HTTP()))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/a/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "content of a/e.txt" {
+		t.Errorf("%d %q", resp.StatusCode, body)
+	}
+}
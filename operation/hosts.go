@@ -0,0 +1,175 @@
+package operation
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hostSelector is shared by all client types, it knows the io hosts, either
+// configured or discovered through the queryer, and lets the balancer pick
+// one for every request. The clients hold it by pointer so their copies
+// share the host scores.
+type hostSelector struct {
+	ioHosts []string
+	queryer *Queryer
+
+	// m guards the balancer and the logger, SetBalancer and SetLogger may
+	// run while requests use them
+	m        sync.RWMutex
+	balancer Balancer
+	log      Ilog
+
+	checkInterval time.Duration
+	probe         func(ctx context.Context, host string) error
+	checker       *HealthChecker
+	startCheck    sync.Once
+}
+
+func newHostSelector(c *Config, scheme string, transport http.RoundTripper, timeouts timeouts) *hostSelector {
+	var queryer *Queryer = nil
+
+	if len(c.UcHosts) > 0 {
+		queryer = newQueryer(c, scheme, transport, timeouts)
+	}
+
+	s := &hostSelector{
+		ioHosts:  dupStrings(c.IoHosts),
+		queryer:  queryer,
+		balancer: newBalancer(c),
+	}
+	shuffleHosts(s.ioHosts)
+	if c.HealthCheckIntervalMs > 0 {
+		s.checkInterval = time.Duration(c.HealthCheckIntervalMs) * time.Millisecond
+		s.probe = probeWith(scheme, timeouts.httpClient(transport, opQuery))
+	}
+	return s
+}
+
+// startHealthCheck starts the health check with the first request, a client
+// that is never used does not leave a goroutine behind. Once started it runs
+// until Close.
+func (s *hostSelector) startHealthCheck() {
+	if s.checkInterval <= 0 {
+		return
+	}
+	s.startCheck.Do(func() {
+		s.checker = NewHealthChecker(checkReports{s}, func() []string {
+			return s.hosts(context.Background())
+		}, s.checkInterval, s.probe)
+		s.checker.Start()
+	})
+}
+
+// hosts are the io hosts discovered through the uc hosts, or the configured
+// ones while the uc hosts do not answer.
+func (s *hostSelector) hosts(ctx context.Context) []string {
+	if s.queryer != nil {
+		if hosts := s.queryer.queryIoDomains(ctx, s.logger()); len(hosts) > 0 {
+			return hosts
+		}
+	}
+	return s.ioHosts
+}
+
+// logger is the logger of the client, the uc queries log to it as well.
+func (s *hostSelector) logger() Ilog {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.log != nil {
+		return s.log
+	}
+	return elog
+}
+
+func (s *hostSelector) setLogger(l Ilog) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.log = l
+}
+
+func (s *hostSelector) nextHost(ctx context.Context) string {
+	s.startHealthCheck()
+	return s.currentBalancer().Pick(s.hosts(ctx))
+}
+
+// nextHostExcept is nextHost skipping the hosts in failed, unless every
+// host has failed already.
+func (s *hostSelector) nextHostExcept(ctx context.Context, failed map[string]struct{}) string {
+	s.startHealthCheck()
+	hosts := s.hosts(ctx)
+	if len(failed) > 0 {
+		left := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			if _, ok := failed[host]; !ok {
+				left = append(left, host)
+			}
+		}
+		if len(left) > 0 {
+			hosts = left
+		}
+	}
+	return s.currentBalancer().Pick(hosts)
+}
+
+func (s *hostSelector) succeedHost(host string, start time.Time) {
+	s.currentBalancer().Succeed(host, time.Now().Sub(start))
+}
+
+func (s *hostSelector) failHost(host string) {
+	s.currentBalancer().Fail(host)
+}
+
+// answered scores host by the status of its answer, any status below 500
+// comes from a healthy host even when it is an error.
+func (s *hostSelector) answered(host string, start time.Time, statusCode int) {
+	if statusCode < http.StatusInternalServerError {
+		s.succeedHost(host, start)
+	} else {
+		s.failHost(host)
+	}
+}
+
+func (s *hostSelector) currentBalancer() Balancer {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.balancer
+}
+
+// SetBalancer replaces the balancer, it is safe while requests are running
+// and the health check reports to the new one from then on.
+func (s *hostSelector) SetBalancer(b Balancer) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.balancer = b
+}
+
+// checkReports hands the results of the health check to the selector, so
+// they reach the balancer set last rather than the one the check started
+// with.
+type checkReports struct {
+	s *hostSelector
+}
+
+func (r checkReports) Pick(hosts []string) string {
+	return r.s.currentBalancer().Pick(hosts)
+}
+
+func (r checkReports) Succeed(host string, latency time.Duration) {
+	r.s.currentBalancer().Succeed(host, latency)
+}
+
+func (r checkReports) Fail(host string) {
+	r.s.currentBalancer().Fail(host)
+}
+
+// Close stops the background health check if one was started, and keeps a
+// later request from starting it.
+func (s *hostSelector) Close() error {
+	s.startCheck.Do(func() {})
+	if s.checker != nil {
+		s.checker.Stop()
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package operation_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// recordingBalancer picks the first host and counts the outcomes.
+type recordingBalancer struct {
+	m        sync.Mutex
+	succeeds int
+	fails    int
+}
+
+func (b *recordingBalancer) Pick(hosts []string) string {
+	return hosts[0]
+}
+
+func (b *recordingBalancer) Succeed(host string, latency time.Duration) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.succeeds++
+}
+
+func (b *recordingBalancer) Fail(host string) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.fails++
+}
+
+// Error answers below 500 come from a healthy host, they must not push it
+// out of the rotation.
+func TestHostScoresFollowTheStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		fault    *bsttest.Fault
+		call     func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error
+		succeeds int
+		fails    int
+	}{
+		{"delete missing", nil, func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error {
+			return m.DeleteFile("missing")
+		}, 1, 0},
+		{"rename onto existing", nil, func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error {
+			return m.RenameFile("obj", "other")
+		}, 1, 0},
+		{"meta of missing", nil, func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error {
+			_, err := m.MetaInfo("missing")
+			return err
+		}, 1, 0},
+		{"list of missing bucket", nil, func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error {
+			_, err := b.ListObject("missing", "", "10", "")
+			return err
+		}, 1, 0},
+		{"make existing bucket", nil, func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error {
+			return b.MakeBucket("bucket")
+		}, 1, 0},
+		{"range past the end", nil, func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error {
+			_, _, err := d.DownloadRangeBytes("obj", 100, 5)
+			return err
+		}, 1, 0},
+		{"server error", &bsttest.Fault{ErrorRate: 1}, func(m *operation.Modify, b *operation.Bucketer, d *operation.Downloader) error {
+			return m.DeleteFile("obj")
+		}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", []byte("data"))
+			s.PutObject("bucket", "other", []byte("data"))
+			s.SetFault(tt.fault)
+			cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1}
+			balancer := &recordingBalancer{}
+			m := operation.NewModifier(cfg)
+			defer m.Close()
+			m.SetBalancer(balancer)
+			b := operation.NewBucketer(cfg)
+			defer b.Close()
+			b.SetBalancer(balancer)
+			d := operation.NewDownloader(cfg)
+			defer d.Close()
+			d.SetBalancer(balancer)
+			if err := tt.call(m, b, d); err == nil {
+				t.Fatal("call succeeded")
+			}
+			if balancer.succeeds != tt.succeeds || balancer.fails != tt.fails {
+				t.Errorf("%d succeeded, %d failed, want %d and %d", balancer.succeeds, balancer.fails, tt.succeeds, tt.fails)
+			}
+		})
+	}
+}
+
+func TestHealthCheckRunsFromFirstRequestToClose(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.PutObject("bucket", "obj", []byte("data"))
+	d := operation.NewDownloader(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", HealthCheckIntervalMs: 5})
+
+	time.Sleep(30 * time.Millisecond)
+	if n := s.Count(""); n != 0 {
+		t.Fatalf("%d probes before the first request", n)
+	}
+	if _, err := d.DownloadBytes("obj"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := s.Count(""); n == 0 {
+		t.Fatal("no probes after the first request")
+	}
+	d.Close()
+	time.Sleep(10 * time.Millisecond)
+	n := s.Count("")
+	time.Sleep(30 * time.Millisecond)
+	if m := s.Count(""); m != n {
+		t.Errorf("%d probes after Close", m-n)
+	}
+}
+
+func TestHealthCheckTakesDeadHostOut(t *testing.T) {
+	c := bsttest.NewCluster(2, "bucket")
+	defer c.Close()
+	c[0].PutObject("bucket", "obj", []byte("data"))
+	c[0].SetFault(&bsttest.Fault{ErrorRate: 1})
+	cl, err := operation.NewClient(&operation.Config{
+		IoHosts:               c.Hosts(),
+		Bucket:                "bucket",
+		Balancer:              operation.BalancerLatency,
+		HealthCheckIntervalMs: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	// the first request starts the health check, the one of the uploads is
+	// shared with the downloads
+	if err = cl.UploadBytes([]byte("data"), "new", true, false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	before := c[0].Count("getfile")
+	for i := 0; i < 20; i++ {
+		if _, err = cl.DownloadBytes("obj"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c[0].Count("getfile") - before; n != 0 {
+		t.Errorf("%d downloads sent to the dead host", n)
+	}
+}
+
+// SetBalancer may run while requests pick hosts, and the health check that
+// has started reports to the new balancer from then on.
+func TestSetBalancerWhileRunning(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.PutObject("bucket", "obj", []byte("data"))
+	d := operation.NewDownloader(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", HealthCheckIntervalMs: 5})
+	defer d.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := d.DownloadBytes("obj"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	balancer := &recordingBalancer{}
+	d.SetBalancer(balancer)
+	wg.Wait()
+
+	balancer.m.Lock()
+	before := balancer.succeeds
+	balancer.m.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	balancer.m.Lock()
+	defer balancer.m.Unlock()
+	if balancer.succeeds == before {
+		t.Error("health check does not report to the new balancer")
+	}
+}
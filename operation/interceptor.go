@@ -0,0 +1,75 @@
+package operation
+
+import (
+	"net/http"
+)
+
+// RequestInfo describes a request to the interceptors. Key is the prefix for
+// listings and the source key for copies, empty for bucket operations.
+type RequestInfo struct {
+	Op     Operation
+	Host   string
+	Bucket string
+	Key    string
+}
+
+// Handler sends a request and returns its response.
+type Handler func(info *RequestInfo, req *http.Request) (*http.Response, error)
+
+// Interceptor wraps every request of a client. It may change req before
+// passing it to next, look at or replace the response, or answer without
+// calling next at all:
+//
+//	c.Use(func(info *RequestInfo, req *http.Request, next Handler) (*http.Response, error) {
+//		req.Header.Set("X-Tenant", tenant)
+//		start := time.Now()
+//		resp, err := next(info, req)
+//		log.Println(info.Op, info.Bucket, info.Key, info.Host, time.Since(start), err)
+//		return resp, err
+//	})
+//
+// Interceptors run for every attempt of a retried operation, before the
+// request is signed, so headers they add are covered by the signature. An
+// error they return counts as a failure of the host, a response they make
+// up needs a Body. The uc queries and health checks do not go through them.
+type Interceptor func(info *RequestInfo, req *http.Request, next Handler) (*http.Response, error)
+
+// Use appends interceptors to the chain of this client, the first one added
+// is the outermost. The operations of a Client share one chain.
+func (b *clientBase) Use(interceptors ...Interceptor) {
+	b.settings.Lock()
+	defer b.settings.Unlock()
+	// the chain is copied, requests in flight keep running the old one
+	b.interceptors = append(b.interceptors[:len(b.interceptors):len(b.interceptors)], interceptors...)
+}
+
+// do runs req through the interceptors, then signs it and sends it with the
+// timeout of op. Every request of the clients goes through it.
+func (b *clientBase) do(op Operation, host, bucket, key string, req *http.Request) (*http.Response, error) {
+	info := &RequestInfo{Op: op, Host: host, Bucket: bucket, Key: key}
+	b.settings.RLock()
+	interceptors := b.interceptors
+	b.settings.RUnlock()
+	return b.chain(interceptors, 0)(info, req)
+}
+
+func (b *clientBase) chain(interceptors []Interceptor, i int) Handler {
+	if i == len(interceptors) {
+		return b.send
+	}
+	return func(info *RequestInfo, req *http.Request) (*http.Response, error) {
+		return interceptors[i](info, req, b.chain(interceptors, i+1))
+	}
+}
+
+func (b *clientBase) send(info *RequestInfo, req *http.Request) (*http.Response, error) {
+	if err := b.currentSigner().Sign(req); err != nil {
+		return nil, err
+	}
+	resp, err := b.timeouts.httpClient(b.transport, info.Op).Do(req)
+	if err != nil {
+		return nil, requestTimeout(req, err)
+	}
+	resp.Body = &timeoutBody{ReadCloser: resp.Body, req: req}
+	return resp, nil
+}
@@ -0,0 +1,128 @@
+package operation_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// The first interceptor added is the outermost, each sees the info of the
+// request.
+func TestInterceptorOrder(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var (
+		m     sync.Mutex
+		trace []string
+	)
+	record := func(name string) operation.Interceptor {
+		return func(info *operation.RequestInfo, req *http.Request, next operation.Handler) (*http.Response, error) {
+			m.Lock()
+			trace = append(trace, name+" "+string(info.Op)+" "+info.Bucket+"/"+info.Key)
+			m.Unlock()
+			resp, err := next(info, req)
+			m.Lock()
+			trace = append(trace, name+" done")
+			m.Unlock()
+			return resp, err
+		}
+	}
+	c.Use(record("outer"), record("middle"))
+	c.Use(record("inner"))
+	if err = c.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "outer upload bucket/obj,middle upload bucket/obj,inner upload bucket/obj,inner done,middle done,outer done"
+	if got := strings.Join(trace, ","); got != want {
+		t.Errorf("trace %s", got)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name        string
+		interceptor func(calls *int) operation.Interceptor
+		credentials bool
+		err         error
+		calls       int
+		requests    int
+	}{
+		{"header covered by the signature", func(calls *int) operation.Interceptor {
+			return func(info *operation.RequestInfo, req *http.Request, next operation.Handler) (*http.Response, error) {
+				*calls++
+				req.Header.Set("X-Tenant", "tenant")
+				return next(info, req)
+			}
+		}, true, nil, 1, 1},
+		{"made up response", func(calls *int) operation.Interceptor {
+			return func(info *operation.RequestInfo, req *http.Request, next operation.Handler) (*http.Response, error) {
+				*calls++
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     make(http.Header),
+					Body:       ioutil.NopCloser(strings.NewReader("cached")),
+					Request:    req,
+				}, nil
+			}
+		}, false, nil, 1, 0},
+		{"error retried", func(calls *int) operation.Interceptor {
+			return func(info *operation.RequestInfo, req *http.Request, next operation.Handler) (*http.Response, error) {
+				*calls++
+				if *calls == 1 {
+					return nil, errors.New("refused")
+				}
+				return next(info, req)
+			}
+		}, false, nil, 2, 1},
+		{"error on every attempt", func(calls *int) operation.Interceptor {
+			return func(info *operation.RequestInfo, req *http.Request, next operation.Handler) (*http.Response, error) {
+				*calls++
+				return nil, errors.New("refused")
+			}
+		}, false, operation.ErrHostUnavailable, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", []byte("data"))
+			cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 3, RetryBaseDelayMs: 1}
+			if tt.credentials {
+				s.SetCredentials("ak", "sk")
+				cfg.AccessKey, cfg.SecretKey = "ak", "sk"
+			}
+			c, err := operation.NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			calls := 0
+			c.Use(tt.interceptor(&calls))
+			got, err := c.DownloadBytes("obj")
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+			} else if err != nil || len(got) == 0 {
+				t.Fatalf("%q, %v", got, err)
+			}
+			if calls != tt.calls {
+				t.Errorf("%d calls, want %d", calls, tt.calls)
+			}
+			if n := s.Count("getfile"); n != tt.requests {
+				t.Errorf("%d requests reached the server, want %d", n, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultListPageSize = 1000
+
+// ListOptions selects what an ObjectIterator walks. Keys come in ascending
+// order, the iterator relies on the server listing them so and stops with an
+// error on a page out of order rather than skip or repeat keys. With a
+// Delimiter, keys that have it after Prefix are folded into one
+// entry per common prefix, like the directories of a file system. Only keys
+// after StartAfter are returned, pass the last key seen to continue a walk.
+type ListOptions struct {
+	Prefix     string
+	Delimiter  string
+	StartAfter string
+	PageSize   int
+}
+
+// ObjectEntry is an object, or with CommonPrefix set the group of keys a
+// delimiter folded together, whose Name ends with the delimiter.
+type ObjectEntry struct {
+	BstFile
+	CommonPrefix bool
+}
+
+// listPageFunc fetches one page, pages count from 1. It returns the files of
+// the page and the number of keys on all pages.
+type listPageFunc func(ctx context.Context, page, size int) ([]BstFile, int, error)
+
+// ObjectIterator pages through a listing as it is consumed:
+//
+//	it := m.Objects(&ListOptions{Prefix: "logs/"})
+//	for it.Next() {
+//		fmt.Println(it.Entry().Name)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// The listing is paged by offset on the server, keys added or removed while
+// it runs may be missed, but a key is never returned twice.
+type ObjectIterator struct {
+	ctx   context.Context
+	opts  ListOptions
+	fetch listPageFunc
+
+	page    int
+	pages   int
+	buf     []BstFile
+	i       int
+	lastKey string
+	group   string
+	entry   ObjectEntry
+	err     error
+	done    bool
+}
+
+func newObjectIterator(ctx context.Context, opts *ListOptions, fetch listPageFunc) *ObjectIterator {
+	it := &ObjectIterator{ctx: ctx, fetch: fetch}
+	if opts != nil {
+		it.opts = *opts
+	}
+	if it.opts.PageSize <= 0 {
+		it.opts.PageSize = defaultListPageSize
+	}
+	it.lastKey = it.opts.StartAfter
+	return it
+}
+
+func (d *Modify) Objects(opts *ListOptions) *ObjectIterator {
+	return d.ObjectsWithContext(context.Background(), opts)
+}
+
+// ObjectsWithContext walks the configured bucket, ctx is used for every page.
+func (d *Modify) ObjectsWithContext(ctx context.Context, opts *ListOptions) *ObjectIterator {
+	prefix := ""
+	if opts != nil {
+		prefix = opts.Prefix
+	}
+	return newObjectIterator(ctx, opts, func(ctx context.Context, page, size int) ([]BstFile, int, error) {
+		var files *BstFiles
+		err := d.retryPolicy().do(ctx, d.logger(), OpListObject, func() (err error) {
+			files, err = d.listObjInner(ctx, prefix, size, page)
+			return
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return files.Data, files.Len, nil
+	})
+}
+
+func (b *Bucketer) Objects(bucketName string, opts *ListOptions) *ObjectIterator {
+	return b.ObjectsWithContext(context.Background(), bucketName, opts)
+}
+
+// ObjectsWithContext walks bucketName, ctx is used for every page.
+func (b *Bucketer) ObjectsWithContext(ctx context.Context, bucketName string, opts *ListOptions) *ObjectIterator {
+	prefix := ""
+	if opts != nil {
+		prefix = opts.Prefix
+	}
+	return newObjectIterator(ctx, opts, func(ctx context.Context, page, size int) ([]BstFile, int, error) {
+		list, err := b.ListObjectWithContext(ctx, bucketName, prefix, strconv.Itoa(size), strconv.Itoa(page))
+		if err != nil {
+			return nil, 0, err
+		}
+		files := make([]BstFile, len(list.Data))
+		for i, f := range list.Data {
+			files[i] = BstFile(f)
+		}
+		return files, list.Len, nil
+	})
+}
+
+// Next moves to the next entry, it returns false at the end of the listing
+// or on an error.
+func (it *ObjectIterator) Next() bool {
+	for !it.done {
+		if it.i >= len(it.buf) {
+			if !it.nextPage() {
+				return false
+			}
+			continue
+		}
+		f := it.buf[it.i]
+		it.i++
+		if f.Name <= it.lastKey || !strings.HasPrefix(f.Name, it.opts.Prefix) {
+			continue
+		}
+		if it.group != "" && strings.HasPrefix(f.Name, it.group) {
+			continue
+		}
+		it.lastKey = f.Name
+		it.entry = ObjectEntry{BstFile: f}
+		if it.opts.Delimiter != "" {
+			rest := f.Name[len(it.opts.Prefix):]
+			if i := strings.Index(rest, it.opts.Delimiter); i >= 0 {
+				it.group = it.opts.Prefix + rest[:i+len(it.opts.Delimiter)]
+				it.entry = ObjectEntry{BstFile: BstFile{Name: it.group, Dir: true}, CommonPrefix: true}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (it *ObjectIterator) nextPage() bool {
+	if it.pages > 0 && it.page >= it.pages {
+		it.done = true
+		return false
+	}
+	if it.page == 0 {
+		return it.firstPage()
+	}
+	return it.load(it.page + 1)
+}
+
+// firstPage loads page 1, or with StartAfter set the first page that has a
+// key after it, found by bisecting the pages.
+func (it *ObjectIterator) firstPage() bool {
+	if !it.load(1) {
+		return false
+	}
+	if it.opts.StartAfter == "" || it.pastStart() {
+		return true
+	}
+	lo, hi := 2, it.pages
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if !it.load(mid) {
+			return false
+		}
+		if it.pastStart() {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	if lo > it.pages {
+		it.done = true
+		return false
+	}
+	if it.page != lo {
+		return it.load(lo)
+	}
+	return true
+}
+
+func (it *ObjectIterator) pastStart() bool {
+	return len(it.buf) > 0 && it.buf[len(it.buf)-1].Name > it.opts.StartAfter
+}
+
+func (it *ObjectIterator) load(page int) bool {
+	if err := it.ctx.Err(); err != nil {
+		it.err, it.done = err, true
+		return false
+	}
+	files, total, err := it.fetch(it.ctx, page, it.opts.PageSize)
+	if err == nil {
+		err = it.checkOrder(page, files)
+	}
+	if err != nil {
+		it.err, it.done = err, true
+		return false
+	}
+	it.page, it.buf, it.i = page, files, 0
+	it.pages = (total + it.opts.PageSize - 1) / it.opts.PageSize
+	if len(files) == 0 {
+		it.done = true
+		return false
+	}
+	return true
+}
+
+// checkOrder fails unless the keys of page ascend, from the last key of the
+// page before when that is the one loaded.
+func (it *ObjectIterator) checkOrder(page int, files []BstFile) error {
+	prev := ""
+	if page == it.page+1 && len(it.buf) > 0 {
+		prev = it.buf[len(it.buf)-1].Name
+	}
+	for i, f := range files {
+		if (i > 0 || prev != "") && f.Name <= prev {
+			return fmt.Errorf("list objects: page %d out of order, %q after %q", page, f.Name, prev)
+		}
+		prev = f.Name
+	}
+	return nil
+}
+
+func (it *ObjectIterator) Entry() ObjectEntry {
+	return it.entry
+}
+
+// Err is the error that ended the listing, nil when it ran to the end.
+func (it *ObjectIterator) Err() error {
+	return it.err
+}
+
+// Chan runs the iterator on a goroutine and sends the entries, the channel is
+// closed at the end, check Err afterwards. Cancel the context of the iterator
+// to stop early, otherwise the goroutine waits on the channel forever.
+func (it *ObjectIterator) Chan() <-chan ObjectEntry {
+	ch := make(chan ObjectEntry, it.opts.PageSize)
+	go func() {
+		defer close(ch)
+		for it.Next() {
+			select {
+			case ch <- it.Entry():
+			case <-it.ctx.Done():
+				it.err = it.ctx.Err()
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,161 @@
+package operation_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+)
+
+// listSeed holds k00 to k19 and a few keys under dir/, 23 keys that make 8
+// pages of 3.
+var listSeed = func() map[string]string {
+	seed := map[string]string{"dir/a": "data", "dir/b": "data", "dir/c/d": "data"}
+	for i := 0; i < 20; i++ {
+		seed[fmt.Sprintf("k%02d", i)] = "data"
+	}
+	return seed
+}()
+
+func keyRange(from, to int) []string {
+	var keys []string
+	for i := from; i < to; i++ {
+		keys = append(keys, fmt.Sprintf("k%02d", i))
+	}
+	return keys
+}
+
+func TestObjectIterator(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     operation.ListOptions
+		want     []string
+		maxPages int
+	}{
+		{"everything", operation.ListOptions{}, append([]string{"dir/a", "dir/b", "dir/c/d"}, keyRange(0, 20)...), 8},
+		{"prefix", operation.ListOptions{Prefix: "dir/"}, []string{"dir/a", "dir/b", "dir/c/d"}, 1},
+		{"delimiter", operation.ListOptions{Delimiter: "/"}, append([]string{"dir/"}, keyRange(0, 20)...), 8},
+		{"delimiter under prefix", operation.ListOptions{Prefix: "dir/", Delimiter: "/"}, []string{"dir/a", "dir/b", "dir/c/"}, 1},
+		{"start after", operation.ListOptions{StartAfter: "k15"}, keyRange(16, 20), 6},
+		{"start after a missing key", operation.ListOptions{StartAfter: "k05x"}, keyRange(6, 20), 8},
+		{"start after the last key", operation.ListOptions{StartAfter: "k19"}, nil, 6},
+		{"start after under a delimiter", operation.ListOptions{Delimiter: "/", StartAfter: "dir/b"}, append([]string{"dir/"}, keyRange(0, 20)...), 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newTestClient(t, listSeed, nil)
+			opts := tt.opts
+			opts.PageSize = 3
+			var got []string
+			it := c.Objects(&opts)
+			for it.Next() {
+				e := it.Entry()
+				if e.CommonPrefix != (e.Name[len(e.Name)-1] == '/') {
+					t.Errorf("%s: common prefix %v", e.Name, e.CommonPrefix)
+				}
+				got = append(got, e.Name)
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("listed %v, want %v", got, tt.want)
+			}
+			if n := s.Count("listobject"); n > tt.maxPages {
+				t.Errorf("%d pages fetched, want at most %d", n, tt.maxPages)
+			}
+		})
+	}
+}
+
+func TestObjectIteratorOfBucket(t *testing.T) {
+	_, c := newTestClient(t, listSeed, nil)
+	var got []string
+	for e := range c.Bucketer.Objects("bucket", &operation.ListOptions{Prefix: "k1", PageSize: 4}).Chan() {
+		got = append(got, e.Name)
+	}
+	if want := keyRange(10, 20); !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+
+	it := c.Bucketer.Objects("missing", nil)
+	if it.Next() || it.Err() == nil {
+		t.Error("listing of a missing bucket did not fail")
+	}
+}
+
+func TestObjectIteratorStopsWithContext(t *testing.T) {
+	s, c := newTestClient(t, listSeed, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	it := c.ObjectsWithContext(ctx, &operation.ListOptions{PageSize: 3})
+	for i := 0; i < 3 && it.Next(); i++ {
+	}
+	cancel()
+	for it.Next() {
+	}
+	if it.Err() != context.Canceled {
+		t.Errorf("err = %v", it.Err())
+	}
+	if n := s.Count("listobject"); n != 1 {
+		t.Errorf("%d pages fetched after the cancel", n-1)
+	}
+}
+
+// A server that lists a page out of order fails the iteration instead of
+// having keys skipped or repeated.
+func TestObjectIteratorRejectsUnsortedPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		reorder func(files []operation.BstFile)
+	}{
+		{"within a page", func(files []operation.BstFile) {
+			files[0], files[1] = files[1], files[0]
+		}},
+		{"across pages", func(files []operation.BstFile) {
+			files[0].Name = "a"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestClient(t, listSeed, nil)
+			// the front reorders the second page
+			front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.URL.Path, "/objects/listobject/") || r.Header.Get("page") != "2" {
+					s.ServeHTTP(w, r)
+					return
+				}
+				rec := httptest.NewRecorder()
+				s.ServeHTTP(rec, r)
+				var files operation.BstFiles
+				if err := json.Unmarshal(rec.Body.Bytes(), &files); err != nil {
+					t.Error(err)
+				}
+				tt.reorder(files.Data)
+				json.NewEncoder(w).Encode(files)
+			}))
+			defer front.Close()
+			c, err := operation.NewClient(&operation.Config{IoHosts: []string{strings.TrimPrefix(front.URL, "http://")}, Bucket: "bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			it := c.Objects(&operation.ListOptions{PageSize: 3})
+			n := 0
+			for it.Next() {
+				n++
+			}
+			if it.Err() == nil {
+				t.Fatalf("listed %d keys without an error", n)
+			}
+			if n != 3 {
+				t.Errorf("listed %d keys before the error, want the 3 of the first page", n)
+			}
+		})
+	}
+}
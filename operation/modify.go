@@ -1,25 +1,27 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/qiniupd/qiniu-go-sdk/x/log.v7"
 	"io/ioutil"
-	"net"
 	"net/http"
-	"sync/atomic"
+	"strconv"
 	"time"
 )
 
 type Modify struct {
-	bucket  string
-	ioHosts []string
-	queryer *Queryer
+	bucket string
+	*clientBase
+	batchConcurrency int
+	partSize         int64
+	noServerCopy     int32
 }
 
 type ExHeader struct {
-	Floder []string `json:"floder"`
+	Floder   []string `json:"floder"`
+	Checksum []string `json:"checksum"`
 }
 
 type MetaInfo struct {
@@ -46,62 +48,21 @@ type BstFile struct {
 	Dir  bool   `json:"isDir"`
 }
 
-var modifyClient = &http.Client{
-	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   1 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	},
-	Timeout: 10 * time.Minute,
-}
-
-func (d *Modify) nextHost() string {
-	ioHosts := d.ioHosts
-	if d.queryer != nil {
-		if hosts := d.queryer.QueryIoHosts(false); len(hosts) > 0 {
-			shuffleHosts(hosts)
-			ioHosts = hosts
-		}
-	}
-	switch len(ioHosts) {
-	case 0:
-		panic("No Io hosts is configured")
-	case 1:
-		return ioHosts[0]
-	default:
-		var ioHost string
-		for i := 0; i <= len(ioHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curIoHostIndex, 1) - 1)
-			ioHost = ioHosts[index%len(ioHosts)]
-			if isHostNameValid(ioHost) {
-				break
-			}
-		}
-		return ioHost
-	}
-}
-
-func (d *Modify) deleteFileInner(key string) error {
-	host := d.nextHost()
+func (d *Modify) deleteFileInner(ctx context.Context, key string) error {
+	host := d.nextHost(ctx)
+	start := time.Now()
 	//fmt.Printf("delete File %s \n", d.bucket)
-	url := fmt.Sprintf("http://%s/objects/deletefile/%s/%s", host, d.bucket, key)
-	req, err := http.NewRequest("DELETE", url, nil)
+	url := fmt.Sprintf("%s/objects/deletefile/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return err
 	}
 	req.Header.Set("Accept-Encoding", "")
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpDelete, host, d.bucket, key, req)
 	if err != nil {
-		failHostName(host)
-		return err
+		d.failHost(host)
+		return hostError(OpDelete, host, d.bucket, key, err)
 	}
 	defer response.Body.Close()
 
@@ -111,28 +72,29 @@ func (d *Modify) deleteFileInner(key string) error {
 	}
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return errors.New(string(body))
+		d.answered(host, start, response.StatusCode)
+		return responseError(OpDelete, host, d.bucket, key, response, body)
 	}
-	succeedHostName(host)
+	d.succeedHost(host, start)
 	return nil
 }
 
-func (d *Modify) renameInner(key string, newName string) error {
-	host := d.nextHost()
-	fmt.Printf("rename File %s \n", d.bucket)
-	url := fmt.Sprintf("http://%s/objects/rename/%s/%s", host, d.bucket, key)
-	req, err := http.NewRequest("PUT", url, nil)
+func (d *Modify) renameInner(ctx context.Context, key string, newName string) error {
+	host := d.nextHost(ctx)
+	start := time.Now()
+	d.logger().Debug("rename", d.bucket, key, newName)
+	url := fmt.Sprintf("%s/objects/rename/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return err
 	}
 	req.Header.Set("Accept-Encoding", "")
 	req.Header.Set("newname", newName)
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpRename, host, d.bucket, key, req)
 	if err != nil {
-		failHostName(host)
-		return err
+		d.failHost(host)
+		return hostError(OpRename, host, d.bucket, key, err)
 	}
 	defer response.Body.Close()
 
@@ -142,28 +104,29 @@ func (d *Modify) renameInner(key string, newName string) error {
 	}
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return errors.New(string(body))
+		d.answered(host, start, response.StatusCode)
+		return responseError(OpRename, host, d.bucket, key, response, body)
 	}
-	succeedHostName(host)
+	d.succeedHost(host, start)
 	return nil
 }
 
-func (d *Modify) metaInfoInner(key string) (*MetaInfo, error) {
-	host := d.nextHost()
+func (d *Modify) metaInfoInner(ctx context.Context, key string) (*MetaInfo, error) {
+	host := d.nextHost(ctx)
+	start := time.Now()
 	log.Infof("metaInfo File %s \n", d.bucket)
-	url := fmt.Sprintf("http://%s/objects/metadetail", host)
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/objects/metadetail", d.hostUrl(host))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return nil, err
 	}
 	req.Header.Set("object", key)
 	req.Header.Set("bucket", d.bucket)
-	response, err := downloadClient.Do(req)
+	response, err := d.do(OpMetaInfo, host, d.bucket, key, req)
 	if err != nil {
-		failHostName(host)
-		return nil, err
+		d.failHost(host)
+		return nil, hostError(OpMetaInfo, host, d.bucket, key, err)
 	}
 	defer response.Body.Close()
 
@@ -172,13 +135,9 @@ func (d *Modify) metaInfoInner(key string) (*MetaInfo, error) {
 		return nil, err
 	}
 
-	if response.StatusCode == http.StatusNotFound {
-		return nil, errors.New("Object Not Found")
-	}
-
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return nil, errors.New(string(body))
+		d.answered(host, start, response.StatusCode)
+		return nil, responseError(OpMetaInfo, host, d.bucket, key, response, body)
 	}
 
 	metaInfoJson := MetaInfo{}
@@ -190,25 +149,29 @@ func (d *Modify) metaInfoInner(key string) (*MetaInfo, error) {
 	if metaInfoJson.Exheaders.Floder != nil {
 		metaInfoJson.Dir = true
 	}
-	succeedHostName(host)
+	d.succeedHost(host, start)
 	return &metaInfoJson, nil
 }
 
-func (d *Modify) listObjInner(prefix string, size int) (*BstFiles, error) {
-	host := d.nextHost()
+func (d *Modify) listObjInner(ctx context.Context, prefix string, size, page int) (*BstFiles, error) {
+	host := d.nextHost(ctx)
+	start := time.Now()
 	log.Infof("listObject Files %s \n", d.bucket)
-	url := fmt.Sprintf("http://%s/objects/listobject/%s", host, d.bucket)
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/objects/listobject/%s", d.hostUrl(host), d.bucket)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.failHost(host)
 		return nil, err
 	}
 	req.Header.Set("size", fmt.Sprintf("%d", size))
 	req.Header.Set("Prefix", prefix)
-	response, err := downloadClient.Do(req)
+	if page > 0 {
+		req.Header.Set("Page", strconv.Itoa(page))
+	}
+	response, err := d.do(OpListObject, host, d.bucket, prefix, req)
 	if err != nil {
-		failHostName(host)
-		return nil, err
+		d.failHost(host)
+		return nil, hostError(OpListObject, host, d.bucket, prefix, err)
 	}
 	defer response.Body.Close()
 
@@ -217,8 +180,8 @@ func (d *Modify) listObjInner(prefix string, size int) (*BstFiles, error) {
 		return nil, err
 	}
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return nil, errors.New(string(body))
+		d.answered(host, start, response.StatusCode)
+		return nil, responseError(OpListObject, host, d.bucket, prefix, response, body)
 	}
 	bstFiles := BstFiles{}
 	jsonErr := json.Unmarshal(body, &bstFiles)
@@ -226,64 +189,82 @@ func (d *Modify) listObjInner(prefix string, size int) (*BstFiles, error) {
 	if jsonErr != nil {
 		return nil, jsonErr
 	}
-	succeedHostName(host)
+	d.succeedHost(host, start)
 	return &bstFiles, nil
 }
 
-func NewModifier(c *Config) *Modify {
+// listAll lists every object under prefix.
+func (d *Modify) listAll(ctx context.Context, prefix string) ([]BstFile, error) {
+	var all []BstFile
+	it := d.ObjectsWithContext(ctx, &ListOptions{Prefix: prefix})
+	for it.Next() {
+		all = append(all, it.Entry().BstFile)
+	}
+	return all, it.Err()
+}
 
-	var queryer *Queryer = nil
+func NewModifier(c *Config) *Modify {
 
 	deleter := Modify{
-		bucket:  c.Bucket,
-		ioHosts: dupStrings(c.IoHosts),
-		queryer: queryer,
+		bucket:           c.Bucket,
+		clientBase:       newClientBase(c),
+		batchConcurrency: c.BatchConcurrency,
+		partSize:         c.PartSize,
 	}
-	shuffleHosts(deleter.ioHosts)
 	return &deleter
 }
 
 func (d *Modify) DeleteFile(key string) (err error) {
-	for i := 0; i < 3; i++ {
-		err = d.deleteFileInner(key)
-		if err == nil {
-			return nil
-		}
-	}
-	return err
+	return d.DeleteFileWithContext(context.Background(), key)
+}
+
+func (d *Modify) DeleteFileWithContext(ctx context.Context, key string) (err error) {
+	return d.retryPolicy().do(ctx, d.logger(), OpDelete, func() error {
+		return d.deleteFileInner(ctx, key)
+	})
 }
 
 func (d *Modify) RenameFile(key, newname string) (err error) {
-	for i := 0; i < 3; i++ {
-		err = d.renameInner(key, newname)
-		if err == nil {
-			return nil
-		}
-	}
-	return err
+	return d.RenameFileWithContext(context.Background(), key, newname)
+}
+
+func (d *Modify) RenameFileWithContext(ctx context.Context, key, newname string) (err error) {
+	return d.retryPolicy().do(ctx, d.logger(), OpRename, func() error {
+		return d.renameInner(ctx, key, newname)
+	})
 }
 
 func (d *Modify) MetaInfo(key string) (metaInfo *MetaInfo, err error) {
-	for i := 0; i < 3; i++ {
-		metaInfo, err = d.metaInfoInner(key)
-		if err == nil || err.Error() == "Object Not Found" {
-			break
-		}
-	}
+	return d.MetaInfoWithContext(context.Background(), key)
+}
+
+func (d *Modify) MetaInfoWithContext(ctx context.Context, key string) (metaInfo *MetaInfo, err error) {
+	err = d.retryPolicy().do(ctx, d.logger(), OpMetaInfo, func() (err error) {
+		metaInfo, err = d.metaInfoInner(ctx, key)
+		return
+	})
 	return
 }
 
 func (d *Modify) ListObject(prefix string, size int) (bstFiles *BstFiles, err error) {
-	for i := 0; i < 3; i++ {
-		bstFiles, err = d.listObjInner(prefix, size)
-		if err == nil {
-			break
-		}
-	}
+	return d.ListObjectWithContext(context.Background(), prefix, size)
+}
+
+func (d *Modify) ListObjectWithContext(ctx context.Context, prefix string, size int) (bstFiles *BstFiles, err error) {
+	err = d.retryPolicy().do(ctx, d.logger(), OpListObject, func() (err error) {
+		bstFiles, err = d.listObjInner(ctx, prefix, size, 0)
+		return
+	})
 	return
 }
 
+// LinkGen is the url of name on the next host, an empty protocol uses the
+// scheme of the Config. The url is neither signed nor expiring, use
+// PresignURL for links handed to others.
 func (d *Modify) LinkGen(name string, protocol string) string {
-	host := d.nextHost()
+	host := d.nextHost(context.Background())
+	if protocol == "" {
+		return fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, name)
+	}
 	return fmt.Sprintf("%s://%s/objects/getfile/%s/%s", protocol, host, d.bucket, name)
 }
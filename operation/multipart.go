@@ -0,0 +1,268 @@
+package operation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type partInfo struct {
+	PartNumber int   `json:"partNumber"`
+	Size       int64 `json:"size"`
+}
+
+type initMultipartRet struct {
+	UploadId string `json:"uploadId"`
+}
+
+type completeMultipartArgs struct {
+	Parts []partInfo `json:"parts"`
+}
+
+// multipart reports whether a file of size goes up in parts. Multipart
+// uploads are opt-in, they need UpConcurrency or Resumable on top of a
+// PartSize the file exceeds.
+func (p Uploader) multipart(size int64) bool {
+	return (p.upConcurrency > 0 || p.resumable) && p.partSize > 0 && size > p.partSize
+}
+
+// multipartUnsupported reports whether err is the answer of a server without
+// multipart uploads to initmultipart, the upload then falls back to a single
+// put.
+func multipartUnsupported(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) || e.Op != OpInitMultipart {
+		return false
+	}
+	return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusMethodNotAllowed
+}
+
+// multipartUpload splits data into partSize chunks, uploads them with up to
+// upConcurrency parts in flight, one when it is not set, and commits them as
+// a single object.
+func (p Uploader) multipartUpload(ctx context.Context, key string, data io.ReaderAt, size int64, header map[string]string) error {
+	t := time.Now()
+	var uploadId string
+	err := p.retryPolicy().do(ctx, p.logger(), OpInitMultipart, func() (err error) {
+		uploadId, err = p.initMultipart(ctx, key, header)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	parts := make([]partInfo, p.partCount(size))
+	p.logger().Info("multipart upload", key, uploadId, "parts", len(parts))
+
+	sum, err := p.uploadParts(ctx, key, uploadId, data, size, nil, func(part partInfo, crc uint32) {
+		parts[part.PartNumber-1] = part
+	})
+	if err != nil {
+		p.abortMultipart(key, uploadId)
+		return err
+	}
+	if sum != "" {
+		header[checksumHeader] = sum
+	}
+
+	err = p.commitMultipart(ctx, key, uploadId, parts, header)
+	if err != nil {
+		p.abortMultipart(key, uploadId)
+		return err
+	}
+	p.logger().Info("multipart upload done", key, time.Now().Sub(t))
+	return nil
+}
+
+func (p Uploader) partCount(size int64) int {
+	return int((size + p.partSize - 1) / p.partSize)
+}
+
+// partLen is the size of the part at offset, the last one may be short.
+func (p Uploader) partLen(size, offset int64) int64 {
+	if offset+p.partSize > size {
+		return size - offset
+	}
+	return p.partSize
+}
+
+// uploadParts uploads every part for which skip returns false and calls done
+// with the part and its crc32 once the server has accepted it. With
+// Config.Checksum set it returns the checksum header value of data, the
+// skipped parts are read for it as well.
+func (p Uploader) uploadParts(ctx context.Context, key, uploadId string, data io.ReaderAt, size int64,
+	skip func(partNumber int) bool, done func(part partInfo, crc uint32)) (string, error) {
+
+	hasher, err := newPartHasher(p.checksum)
+	if err != nil {
+		return "", err
+	}
+	err = runTasks(ctx, p.upConcurrency, p.partCount(size), func(ctx context.Context, i int) error {
+		partNumber := i + 1
+		offset := int64(i) * p.partSize
+		partSize := p.partLen(size, offset)
+		src, srcOffset := data, offset
+		if hasher != nil {
+			buf, err := hasher.read(i, data, offset, partSize)
+			if err != nil {
+				return err
+			}
+			src, srcOffset = bytes.NewReader(buf), 0
+		}
+		if skip != nil && skip(partNumber) {
+			progressDone(ctx, partNumber, partSize)
+			return nil
+		}
+		return p.retryPolicy().do(ctx, p.logger(), OpUploadPart, func() error {
+			h := crc32.NewIEEE()
+			r := io.TeeReader(io.NewSectionReader(src, srcOffset, partSize), h)
+			err := p.putPart(ctx, key, uploadId, partNumber, r, partSize)
+			if err == nil {
+				done(partInfo{PartNumber: partNumber, Size: partSize}, h.Sum32())
+			}
+			return err
+		})
+	})
+	if err != nil || hasher == nil {
+		return "", err
+	}
+	return hasher.sum(), nil
+}
+
+func (p Uploader) commitMultipart(ctx context.Context, key, uploadId string, parts []partInfo, header map[string]string) error {
+	return p.retryPolicy().do(ctx, p.logger(), OpCompleteMultipart, func() error {
+		return p.completeMultipart(ctx, key, uploadId, parts, header)
+	})
+}
+
+func (p Uploader) multipartUrl(ctx context.Context, action, key string) (string, string) {
+	upHost := p.nextHost(ctx)
+	url := p.hostUrl(upHost) + "/objects/" + action + "/" + p.bucket
+	if key != "" {
+		url += "/" + key
+	}
+	return upHost, url
+}
+
+func (p Uploader) initMultipart(ctx context.Context, key string, header map[string]string) (string, error) {
+	upHost, url := p.multipartUrl(ctx, "initmultipart", key)
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return "", err
+	}
+	for i, v := range header {
+		req.Header.Set(i, v)
+	}
+	resp, err := p.do(OpInitMultipart, upHost, p.bucket, key, req)
+	if err != nil {
+		p.failHost(upHost)
+		return "", hostError(OpInitMultipart, upHost, p.bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		p.answered(upHost, start, resp.StatusCode)
+		return "", responseError(OpInitMultipart, upHost, p.bucket, key, resp, body)
+	}
+	p.succeedHost(upHost, start)
+
+	ret := initMultipartRet{}
+	if err = json.Unmarshal(body, &ret); err != nil {
+		return "", err
+	}
+	if ret.UploadId == "" {
+		return "", errors.New("empty upload id")
+	}
+	return ret.UploadId, nil
+}
+
+func (p Uploader) putPart(ctx context.Context, key, uploadId string, partNumber int, data io.Reader, size int64) error {
+	upHost, url := p.multipartUrl(ctx, "putpart", key)
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, progressReader(ctx, p.limitReader(ctx, upHost, data), partNumber, 0))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("uploadid", uploadId)
+	req.Header.Set("partnumber", strconv.Itoa(partNumber))
+	req.ContentLength = size
+	resp, err := p.do(OpUploadPart, upHost, p.bucket, key, req)
+	if err != nil {
+		p.failHost(upHost)
+		return hostError(OpUploadPart, upHost, p.bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		p.answered(upHost, start, resp.StatusCode)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return responseError(OpUploadPart, upHost, p.bucket, key, resp, body)
+	}
+	p.succeedHost(upHost, start)
+	return nil
+}
+
+func (p Uploader) completeMultipart(ctx context.Context, key, uploadId string, parts []partInfo, header map[string]string) error {
+	b, err := json.Marshal(completeMultipartArgs{Parts: parts})
+	if err != nil {
+		return err
+	}
+	upHost, url := p.multipartUrl(ctx, "completemultipart", key)
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for i, v := range header {
+		req.Header.Set(i, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("uploadid", uploadId)
+	resp, err := p.do(OpCompleteMultipart, upHost, p.bucket, key, req)
+	if err != nil {
+		p.failHost(upHost)
+		return hostError(OpCompleteMultipart, upHost, p.bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		p.answered(upHost, start, resp.StatusCode)
+		return responseError(OpCompleteMultipart, upHost, p.bucket, key, resp, body)
+	}
+	p.succeedHost(upHost, start)
+	return nil
+}
+
+// abortMultipart is best effort, the server drops stale uploads by itself.
+func (p Uploader) abortMultipart(key, uploadId string) {
+	upHost, url := p.multipartUrl(context.Background(), "abortmultipart", key)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("uploadid", uploadId)
+	resp, err := p.do(OpAbortMultipart, upHost, p.bucket, key, req)
+	if err != nil {
+		p.failHost(upHost)
+		p.logger().Info("abort multipart failed", key, uploadId, err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,130 @@
+package operation_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// writeTempFile writes size random bytes to a file in t.TempDir.
+func writeTempFile(t *testing.T, name string, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file, data
+}
+
+func TestMultipartUpload(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		partSize    int64
+		concurrency int
+		parts       int
+	}{
+		{"single put below the part size", 1000, 4096, 4, 0},
+		{"no part size", 10000, 0, 4, 0},
+		{"concurrent parts", 10000, 4096, 4, 3},
+		{"concurrency not set", 10000, 4096, 0, 0},
+		{"sequential parts", 8192, 4096, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			file, data := writeTempFile(t, "obj", tt.size)
+			p := operation.NewUploader(&operation.Config{
+				IoHosts:       []string{s.Host()},
+				Bucket:        "bucket",
+				PartSize:      tt.partSize,
+				UpConcurrency: tt.concurrency,
+			})
+			defer p.Close()
+			if err := p.Upload(file, "dir/obj", true, false); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := s.Object("bucket", "dir/obj")
+			if !ok || !bytes.Equal(got, data) {
+				t.Fatalf("stored %d bytes, want %d", len(got), len(data))
+			}
+			if n := s.Count("putpart"); n != tt.parts {
+				t.Errorf("putpart = %d, want %d", n, tt.parts)
+			}
+			wantCompletes := 0
+			if tt.parts > 0 {
+				wantCompletes = 1
+			}
+			if n := s.Count("completemultipart"); n != wantCompletes {
+				t.Errorf("completemultipart = %d, want %d", n, wantCompletes)
+			}
+		})
+	}
+}
+
+func TestMultipartUploadAbortsOnFailure(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetFault(&bsttest.Fault{StatusCode: 400, ErrorRate: 1, Match: func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/objects/putpart/")
+	}})
+	file, _ := writeTempFile(t, "obj", 10000)
+	p := operation.NewUploader(&operation.Config{
+		IoHosts:       []string{s.Host()},
+		Bucket:        "bucket",
+		PartSize:      4096,
+		UpConcurrency: 1,
+	})
+	defer p.Close()
+	if err := p.Upload(file, "obj", true, false); err == nil {
+		t.Fatal("upload succeeded")
+	}
+	if _, ok := s.Object("bucket", "obj"); ok {
+		t.Error("object stored")
+	}
+	if n := s.Count("abortmultipart"); n != 1 {
+		t.Errorf("abortmultipart = %d, want 1", n)
+	}
+}
+
+// A server without multipart uploads answers initmultipart with 404 or 405,
+// the upload goes up in a single put instead.
+func TestMultipartUploadFallsBackToPut(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.SetFault(&bsttest.Fault{StatusCode: status, ErrorRate: 1, Match: func(r *http.Request) bool {
+				return strings.HasPrefix(r.URL.Path, "/objects/initmultipart/")
+			}})
+			file, data := writeTempFile(t, "obj", 10000)
+			p := operation.NewUploader(&operation.Config{
+				IoHosts:       []string{s.Host()},
+				Bucket:        "bucket",
+				PartSize:      4096,
+				UpConcurrency: 4,
+			})
+			defer p.Close()
+			if err := p.Upload(file, "obj", true, false); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := s.Object("bucket", "obj")
+			if !ok || !bytes.Equal(got, data) {
+				t.Fatalf("stored %d bytes, want %d", len(got), len(data))
+			}
+			if n := s.Count("putpart"); n != 0 {
+				t.Errorf("putpart = %d, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+var errObjectClosed = errors.New("object closed")
+
+// Object is a read only handle on a stored object, it fetches the bytes
+// asked for with ranged requests and moves to another io host when one
+// fails in the middle of a read. ReadAt may be called concurrently, Read and
+// Seek share a position and must not.
+type Object struct {
+	d    *Downloader
+	ctx  context.Context
+	key  string
+	size int64
+
+	m      sync.Mutex
+	pos    int64
+	body   io.ReadCloser
+	host   string
+	failed map[string]struct{}
+	closed bool
+}
+
+var (
+	_ io.ReaderAt   = (*Object)(nil)
+	_ io.ReadSeeker = (*Object)(nil)
+	_ io.Closer     = (*Object)(nil)
+)
+
+func (d *Downloader) Open(key string) (*Object, error) {
+	return d.OpenWithContext(context.Background(), key)
+}
+
+// OpenWithContext looks up the size of key, ctx is used by every read of the
+// returned Object and must stay alive until it is closed.
+func (d *Downloader) OpenWithContext(ctx context.Context, key string) (*Object, error) {
+	meta, err := d.getFileMeta(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return d.newObject(ctx, key, meta.Size), nil
+}
+
+func (d *Downloader) newObject(ctx context.Context, key string, size int64) *Object {
+	return &Object{
+		d:      d,
+		ctx:    ctx,
+		key:    key,
+		size:   size,
+		failed: make(map[string]struct{}),
+	}
+}
+
+func (o *Object) Key() string {
+	return o.key
+}
+
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ReadAt reads len(p) bytes at off with one ranged request, a broken
+// transfer continues on another host where it stopped. With a block cache on
+// the downloader the bytes come from the cache instead.
+func (o *Object) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	n = len(p)
+	if int64(n) > o.size-off {
+		n = int(o.size - off)
+		err = io.EOF
+	}
+	if n == 0 {
+		return 0, err
+	}
+	if o.d.cache != nil {
+		_, data, rerr := o.d.readRange(o.ctx, o.key, off, int64(n))
+		if rerr != nil {
+			return copy(p, data), rerr
+		}
+		if len(data) < n {
+			return copy(p, data), io.ErrUnexpectedEOF
+		}
+		return copy(p, data), err
+	}
+	w := &sliceWriterAt{buf: p[:n], base: off}
+	if rerr := o.d.downloadRangeTo(o.ctx, o.key, w, 0, off, int64(n)); rerr != nil {
+		return w.written, rerr
+	}
+	return n, err
+}
+
+type sliceWriterAt struct {
+	buf     []byte
+	base    int64
+	written int
+}
+
+func (w *sliceWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n := copy(w.buf[off-w.base:], p)
+	if end := int(off-w.base) + n; end > w.written {
+		w.written = end
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Read streams from the current position, the stream is kept open between
+// calls and reopened from the position on another host if it breaks.
+func (o *Object) Read(p []byte) (n int, err error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.closed {
+		return 0, errObjectClosed
+	}
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err = o.d.retryPolicy().do(o.ctx, o.d.logger(), OpDownload, func() error {
+		if o.body == nil {
+			if err := o.open(); err != nil {
+				return err
+			}
+		}
+		var rerr error
+		n, rerr = o.body.Read(p)
+		o.pos += int64(n)
+		if rerr == io.EOF && o.pos >= o.size {
+			o.drop()
+			return nil
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				rerr = io.ErrUnexpectedEOF
+			}
+			o.failed[o.host] = struct{}{}
+			o.d.failHost(o.host)
+			o.drop()
+			if n > 0 {
+				return nil
+			}
+			return hostError(OpDownload, o.host, o.d.bucket, o.key, rerr)
+		}
+		return nil
+	})
+	return
+}
+
+func (o *Object) open() error {
+	host := o.d.nextHostExcept(o.ctx, o.failed)
+	response, err := o.d.getRange(o.ctx, host, o.key, o.pos, o.size-o.pos)
+	if err != nil {
+		o.failed[host] = struct{}{}
+		return err
+	}
+	o.host = host
+	o.body = &wrapper{
+		s:    response.Body,
+		r:    io.LimitReader(o.d.limitReader(o.ctx, host, response.Body), o.size-o.pos),
+		host: host,
+	}
+	return nil
+}
+
+func (o *Object) drop() {
+	if o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+}
+
+// Seek moves the position of Read, the open stream is only dropped when the
+// position actually changes.
+func (o *Object) Seek(offset int64, whence int) (int64, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.closed {
+		return 0, errObjectClosed
+	}
+	pos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		pos += o.pos
+	case io.SeekEnd:
+		pos += o.size
+	default:
+		return o.pos, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return o.pos, errors.New("negative position")
+	}
+	if pos != o.pos {
+		o.drop()
+		o.pos = pos
+	}
+	return pos, nil
+}
+
+func (o *Object) Close() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.drop()
+	o.closed = true
+	return nil
+}
@@ -0,0 +1,111 @@
+package operation_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestObject(t *testing.T) {
+	const size = 10000
+	faults := []struct {
+		name  string
+		fault *bsttest.Fault
+	}{
+		{"healthy hosts", nil},
+		{"host answering errors", &bsttest.Fault{ErrorRate: 1, Match: isAction("getfile")}},
+		{"host breaking transfers", &bsttest.Fault{DropAfter: 1000, Match: isAction("getfile")}},
+	}
+	reads := []struct {
+		name string
+		read func(o *operation.Object) ([]byte, error)
+		from int
+		to   int
+	}{
+		{"read", func(o *operation.Object) ([]byte, error) {
+			return ioutil.ReadAll(o)
+		}, 0, size},
+		{"seek and read", func(o *operation.Object) ([]byte, error) {
+			if _, err := o.Seek(1000, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return ioutil.ReadAll(o)
+		}, 1000, size},
+		{"seek from end", func(o *operation.Object) ([]byte, error) {
+			if _, err := o.Seek(-2000, io.SeekEnd); err != nil {
+				return nil, err
+			}
+			return ioutil.ReadAll(o)
+		}, size - 2000, size},
+		{"read at", func(o *operation.Object) ([]byte, error) {
+			buf := make([]byte, 5000)
+			n, err := o.ReadAt(buf, 3000)
+			return buf[:n], err
+		}, 3000, 8000},
+		{"read at the end", func(o *operation.Object) ([]byte, error) {
+			buf := make([]byte, 2000)
+			n, err := o.ReadAt(buf, size-1500)
+			if err != io.EOF {
+				return nil, err
+			}
+			return buf[:n], nil
+		}, size - 1500, size},
+	}
+	for _, f := range faults {
+		for _, r := range reads {
+			t.Run(f.name+"/"+r.name, func(t *testing.T) {
+				c := bsttest.NewCluster(2, "bucket")
+				defer c.Close()
+				data := randomBytes(size)
+				c[0].PutObject("bucket", "obj", data)
+				c[0].SetFault(f.fault)
+				d := operation.NewDownloader(&operation.Config{IoHosts: c.Hosts(), Bucket: "bucket", RetryBaseDelayMs: 1})
+				defer d.Close()
+				d.SetBalancer(&recordingBalancer{})
+				o, err := d.Open("obj")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer o.Close()
+				if o.Size() != size {
+					t.Fatalf("size %d", o.Size())
+				}
+				got, err := r.read(o)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data[r.from:r.to]) {
+					t.Fatalf("read %d bytes, want %d", len(got), r.to-r.from)
+				}
+				if f.fault != nil && c[1].Count("getfile") == 0 {
+					t.Error("read did not move to the healthy host")
+				}
+			})
+		}
+	}
+}
+
+func TestObjectClosed(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.PutObject("bucket", "obj", []byte("data"))
+	d := operation.NewDownloader(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+	defer d.Close()
+	o, err := d.Open("obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = o.Read(make([]byte, 4)); err == nil {
+		t.Error("read of a closed object")
+	}
+	if _, err = o.Seek(0, io.SeekStart); err == nil {
+		t.Error("seek of a closed object")
+	}
+}
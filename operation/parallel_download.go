@@ -0,0 +1,154 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/qiniupd/qiniu-go-sdk/x/rpc.v7"
+)
+
+const defaultRangeSize = 16 << 20
+
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return
+}
+
+// DownloadFileParallel downloads key into path by splitting it into PartSize
+// ranges and fetching up to downConcurrency of them at once from different
+// io hosts. A range that fails is retried on another host from where it
+// stopped.
+func (d *Downloader) DownloadFileParallel(key, path string) (*os.File, error) {
+	return d.DownloadFileParallelWithContext(context.Background(), key, path)
+}
+
+func (d *Downloader) DownloadFileParallelWithContext(ctx context.Context, key, path string) (*os.File, error) {
+	meta, err := d.getFileMeta(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size := meta.Size
+	if size <= 0 {
+		return d.DownloadFileWithContext(ctx, key, path)
+	}
+
+	rangeSize := d.partSize
+	if rangeSize <= 0 {
+		rangeSize = defaultRangeSize
+	}
+	concurrency := d.downConcurrency
+	if concurrency <= 0 {
+		concurrency = len(d.hosts(ctx))
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ctx = d.trackProgress(ctx, OpDownload, key, size)
+	count := int((size + rangeSize - 1) / rangeSize)
+	err = runTasks(ctx, concurrency, count, func(ctx context.Context, i int) error {
+		offset := int64(i) * rangeSize
+		n := rangeSize
+		if offset+n > size {
+			n = size - offset
+		}
+		return d.downloadRangeTo(ctx, key, f, i+1, offset, n)
+	})
+	if err == nil && len(meta.Eheader.Checksum) > 0 {
+		err = d.verifyFile(f, size, key, meta.Eheader.Checksum[0])
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	f.Seek(0, io.SeekStart)
+	return f, nil
+}
+
+// verifyFile rereads the assembled file, the ranges come from different
+// hosts and in any order so they cannot be hashed on the way.
+func (d *Downloader) verifyFile(f *os.File, size int64, key, checksum string) error {
+	verifier := newChecksumVerifier(checksum)
+	if verifier == nil {
+		return nil
+	}
+	if _, err := io.Copy(verifier, io.NewSectionReader(f, 0, size)); err != nil {
+		return err
+	}
+	return verifier.verify(OpDownload, "", d.bucket, key)
+}
+
+// downloadRangeTo writes [offset, offset+size) of key into w, every retry
+// switches to a host that has not failed yet and resumes where the broken
+// request stopped. Every host is tried before the range fails. part
+// numbers the range in progress reports.
+func (d *Downloader) downloadRangeTo(ctx context.Context, key string, w io.WriterAt, part int, offset, size int64) error {
+	failedIoHosts := make(map[string]struct{})
+	ow := &offsetWriter{w: w, off: offset}
+	end := offset + size
+	return d.retryPolicy().failover(ctx, d.logger(), OpDownload, len(d.hosts(ctx)), func() error {
+		host := d.nextHostExcept(ctx, failedIoHosts)
+		err := d.downloadRangeToInner(ctx, host, key, ow, end-ow.off, part, ow.off-offset)
+		if err != nil {
+			failedIoHosts[host] = struct{}{}
+		}
+		return err
+	})
+}
+
+func (d *Downloader) downloadRangeToInner(ctx context.Context, host, key string, ow *offsetWriter, size int64, part int, done int64) error {
+	response, err := d.getRange(ctx, host, key, ow.off, size)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.CopyN(ow, progressReader(ctx, d.limitReader(ctx, host, response.Body), part, done), size)
+	if err != nil {
+		d.failHost(host)
+		return err
+	}
+	return nil
+}
+
+// getRange requests size bytes at offset from host, the response is only
+// returned when the server answered with the range.
+func (d *Downloader) getRange(ctx context.Context, host, key string, offset, size int64) (*http.Response, error) {
+	start := time.Now()
+	url := fmt.Sprintf("%s/objects/getfile/%s/%s", d.hostUrl(host), d.bucket, key)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "")
+	req.Header.Set("User-Agent", rpc.UserAgent)
+	req.Header.Set("Range", generateRange(offset, size))
+	response, err := d.do(OpDownload, host, d.bucket, key, req)
+	if err != nil {
+		d.failHost(host)
+		return nil, hostError(OpDownload, host, d.bucket, key, err)
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		d.answered(host, start, response.StatusCode)
+		response.Body.Close()
+		return nil, responseError(OpDownload, host, d.bucket, key, response, nil)
+	}
+	d.succeedHost(host, start)
+	return response, nil
+}
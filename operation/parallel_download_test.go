@@ -0,0 +1,69 @@
+package operation_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func randomBytes(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func isAction(action string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/objects/"+action+"/")
+	}
+}
+
+func TestDownloadFileParallel(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault *bsttest.Fault
+	}{
+		{"healthy hosts", nil},
+		{"host answering errors", &bsttest.Fault{ErrorRate: 1, Match: isAction("getfile")}},
+		{"host dropping connections", &bsttest.Fault{DropRate: 1, Match: isAction("getfile")}},
+		{"host breaking transfers", &bsttest.Fault{DropAfter: 1000, Match: isAction("getfile")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := bsttest.NewCluster(3, "bucket")
+			defer c.Close()
+			data := randomBytes(10*4096 + 7)
+			c[0].PutObject("bucket", "obj", data)
+			c[0].SetFault(tt.fault)
+			d := operation.NewDownloader(&operation.Config{
+				IoHosts:         c.Hosts(),
+				Bucket:          "bucket",
+				PartSize:        4096,
+				DownConcurrency: 4,
+			})
+			defer d.Close()
+			f, err := d.DownloadFileParallel("obj", filepath.Join(t.TempDir(), "obj"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("downloaded %d bytes, want %d", len(got), len(data))
+			}
+			if n := c[1].Count("getfile") + c[2].Count("getfile"); n == 0 {
+				t.Error("ranges not spread over the hosts")
+			}
+		})
+	}
+}
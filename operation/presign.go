@@ -0,0 +1,276 @@
+package operation
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A presigned url carries its signature in the query instead of the
+// Authorization header:
+//
+//	X-Bst-Algorithm=BST-HMAC-SHA256
+//	X-Bst-Credential=<ak>
+//	X-Bst-Date=<signing time>
+//	X-Bst-Expires=<seconds after the date>
+//	X-Bst-Range=bytes=<first>-[<last>]        optional
+//	X-Bst-Security-Token=<token>              optional
+//	response-content-disposition=<value>      optional
+//	X-Bst-Signature=<hex>
+//
+// The signature is the HMAC-SHA256 of the secret key over the algorithm, the
+// date, the method, the escaped path and every other query parameter sorted
+// by name, one per line. Parameters added to a signed url invalidate it.
+const (
+	presignAlgorithmParam   = "X-Bst-Algorithm"
+	presignCredentialParam  = "X-Bst-Credential"
+	presignDateParam        = "X-Bst-Date"
+	presignExpiresParam     = "X-Bst-Expires"
+	presignRangeParam       = "X-Bst-Range"
+	presignTokenParam       = "X-Bst-Security-Token"
+	presignDispositionParam = "response-content-disposition"
+	presignSignatureParam   = "X-Bst-Signature"
+
+	defaultPresignExpires = 15 * time.Minute
+	maxPresignExpires     = 7 * 24 * time.Hour
+	// maxPresignSkew tolerates the clock of the signer running ahead of the
+	// one of the gateway.
+	maxPresignSkew = 15 * time.Minute
+)
+
+// PresignOptions describes a presigned url, nil presigns a GET of the whole
+// object that expires after 15 minutes.
+type PresignOptions struct {
+	// Method is GET or PUT, GET also allows HEAD.
+	Method string
+	// Expires is how long the url is valid, at most 7 days.
+	Expires time.Duration
+	// Protocol overrides the scheme of the Config, like for LinkGen.
+	Protocol string
+	// Offset and Size restrict a GET to a range of the object, Size 0 runs
+	// to the end.
+	Offset int64
+	Size   int64
+	// ContentDisposition is sent back as the Content-Disposition of the
+	// response, e.g. `attachment; filename="report.pdf"`.
+	ContentDisposition string
+}
+
+// PresignedRequest is what a presigned url allows, as returned by
+// VerifyPresigned. Size 0 means to the end of the object.
+type PresignedRequest struct {
+	AccessKey          string
+	SessionToken       string
+	Method             string
+	Path               string
+	Expires            time.Time
+	Offset             int64
+	Size               int64
+	ContentDisposition string
+}
+
+// Range is the Range header that serves the allowed range, empty when the
+// whole object is allowed.
+func (p *PresignedRequest) Range() string {
+	return presignRange(p.Offset, p.Size)
+}
+
+func presignRange(offset, size int64) string {
+	switch {
+	case size > 0:
+		return fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)
+	case offset > 0:
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return ""
+}
+
+func (d *Modify) PresignURL(key string, opts *PresignOptions) (string, error) {
+	return d.PresignURLWithContext(context.Background(), key, opts)
+}
+
+// PresignURLWithContext returns a url of key on the next host that can be
+// used without credentials until it expires, signed with the credentials of
+// the client. Unlike LinkGen it can be handed to external consumers, the
+// server or a gateway in front of it checks it with VerifyPresigned.
+func (d *Modify) PresignURLWithContext(ctx context.Context, key string, opts *PresignOptions) (string, error) {
+	o := PresignOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Method = strings.ToUpper(o.Method)
+	action := "getfile"
+	switch o.Method {
+	case "", "GET":
+		o.Method = "GET"
+	case "PUT":
+		action = "put"
+		if o.Offset != 0 || o.Size != 0 {
+			return "", fmt.Errorf("presign: range of a PUT")
+		}
+	default:
+		return "", fmt.Errorf("presign: unsupported method %s", o.Method)
+	}
+	if o.Expires == 0 {
+		o.Expires = defaultPresignExpires
+	}
+	if o.Expires < time.Second || o.Expires > maxPresignExpires {
+		return "", fmt.Errorf("presign: expiry %v not between 1s and %v", o.Expires, maxPresignExpires)
+	}
+	if o.Offset < 0 || o.Size < 0 {
+		return "", fmt.Errorf("presign: invalid range %d+%d", o.Offset, o.Size)
+	}
+	signer := d.currentSigner()
+	creds, err := signer.credentials(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	host := d.nextHost(ctx)
+	base := d.hostUrl(host)
+	if o.Protocol != "" {
+		base = hostUrl(o.Protocol, host)
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	u.Path = fmt.Sprintf("/objects/%s/%s/%s", action, d.bucket, key)
+
+	query := url.Values{}
+	query.Set(presignAlgorithmParam, signAlgorithm)
+	query.Set(presignCredentialParam, creds.AccessKey)
+	query.Set(presignDateParam, signer.now().UTC().Format(signDateFormat))
+	query.Set(presignExpiresParam, strconv.FormatInt(int64(o.Expires/time.Second), 10))
+	if r := presignRange(o.Offset, o.Size); r != "" {
+		query.Set(presignRangeParam, r)
+	}
+	if creds.SessionToken != "" {
+		query.Set(presignTokenParam, creds.SessionToken)
+	}
+	if o.ContentDisposition != "" {
+		query.Set(presignDispositionParam, o.ContentDisposition)
+	}
+	sig := signature(creds.SecretKey, presignStringToSign(o.Method, u.EscapedPath(), query))
+	query.Set(presignSignatureParam, sig)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// presignStringToSign leaves out the signature from query.
+func presignStringToSign(method, escapedPath string, query url.Values) string {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != presignSignatureParam {
+			signed[name] = values
+		}
+	}
+	return strings.Join([]string{
+		signAlgorithm,
+		query.Get(presignDateParam),
+		method,
+		escapedPath,
+		signed.Encode(),
+	}, "\n") + "\n"
+}
+
+// VerifyPresigned checks a request made with a url of PresignURL, it is meant
+// for the server or a gateway in front of it. secretKey looks up the secret of
+// an access key. The error matches ErrAccessDenied when the url is forged,
+// altered, expired, used with another method or asks for bytes outside of its
+// range. The caller still has to check the session token if it issues any,
+// serve the allowed range when the request has no Range header and send
+// ContentDisposition.
+func VerifyPresigned(r *http.Request, secretKey func(accessKey string) (string, error)) (*PresignedRequest, error) {
+	query := r.URL.Query()
+	if query.Get(presignAlgorithmParam) != signAlgorithm {
+		return nil, presignError("unsupported algorithm")
+	}
+	p := &PresignedRequest{
+		AccessKey:          query.Get(presignCredentialParam),
+		SessionToken:       query.Get(presignTokenParam),
+		Path:               r.URL.Path,
+		ContentDisposition: query.Get(presignDispositionParam),
+	}
+	date, err := time.Parse(signDateFormat, query.Get(presignDateParam))
+	if err != nil {
+		return nil, presignError("bad date")
+	}
+	seconds, err := strconv.ParseInt(query.Get(presignExpiresParam), 10, 64)
+	if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxPresignExpires {
+		return nil, presignError("bad expiry")
+	}
+	p.Expires = date.Add(time.Duration(seconds) * time.Second)
+	if rng := query.Get(presignRangeParam); rng != "" {
+		first, last, ok := parseRange(rng)
+		if !ok {
+			return nil, presignError("bad range")
+		}
+		p.Offset = first
+		if last >= 0 {
+			p.Size = last - first + 1
+		}
+	}
+
+	secret, err := secretKey(p.AccessKey)
+	if err != nil {
+		return nil, fmt.Errorf("presigned url: %w", err)
+	}
+	method := r.Method
+	if method == "HEAD" {
+		method = "GET"
+	}
+	want := signature(secret, presignStringToSign(method, r.URL.EscapedPath(), query))
+	if !hmac.Equal([]byte(want), []byte(query.Get(presignSignatureParam))) {
+		return nil, presignError("signature mismatch")
+	}
+	p.Method = method
+
+	now := time.Now()
+	if now.After(p.Expires) {
+		return nil, presignError("expired")
+	}
+	if date.After(now.Add(maxPresignSkew)) {
+		return nil, presignError("signed in the future")
+	}
+	if rng := r.Header.Get("Range"); rng != "" && (p.Offset > 0 || p.Size > 0) {
+		first, last, ok := parseRange(rng)
+		if !ok || first < p.Offset || p.Size > 0 && (last < 0 || last >= p.Offset+p.Size) {
+			return nil, presignError("range outside of the signed range")
+		}
+	}
+	return p, nil
+}
+
+func presignError(msg string) error {
+	return fmt.Errorf("presigned url %s: %w", msg, ErrAccessDenied)
+}
+
+// parseRange parses a single bytes=first-[last] range, last is -1 when open.
+// Suffix and multiple ranges are not supported.
+func parseRange(s string) (first, last int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes=") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(s, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, false
+	}
+	if strings.TrimSpace(bounds[1]) == "" {
+		return first, -1, true
+	}
+	last, err = strconv.ParseInt(strings.TrimSpace(bounds[1]), 10, 64)
+	if err != nil || last < first {
+		return 0, 0, false
+	}
+	return first, last, true
+}
@@ -0,0 +1,151 @@
+package operation_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// withCredentials signs the requests of the client with ak and sk.
+func withCredentials(ak, sk string) func(c *operation.Config) {
+	return func(c *operation.Config) {
+		c.AccessKey, c.SecretKey = ak, sk
+	}
+}
+
+// Presigned urls are used without credentials by a plain http client.
+func TestPresignURL(t *testing.T) {
+	data := []byte("0123456789")
+	tests := []struct {
+		name    string
+		opts    *operation.PresignOptions
+		request func(u string) (*http.Request, error)
+		status  int
+		body    string
+		check   func(t *testing.T, s *bsttest.Server, resp *http.Response)
+	}{
+		{"get", nil, func(u string) (*http.Request, error) {
+			return http.NewRequest("GET", u, nil)
+		}, http.StatusOK, string(data), nil},
+		{"head", nil, func(u string) (*http.Request, error) {
+			return http.NewRequest("HEAD", u, nil)
+		}, http.StatusOK, "", nil},
+		{"signed range", &operation.PresignOptions{Offset: 2, Size: 3}, func(u string) (*http.Request, error) {
+			return http.NewRequest("GET", u, nil)
+		}, http.StatusPartialContent, "234", nil},
+		{"range within the signed range", &operation.PresignOptions{Offset: 2, Size: 5}, func(u string) (*http.Request, error) {
+			req, err := http.NewRequest("GET", u, nil)
+			if err == nil {
+				req.Header.Set("Range", "bytes=3-4")
+			}
+			return req, err
+		}, http.StatusPartialContent, "34", nil},
+		{"range outside the signed range", &operation.PresignOptions{Offset: 2, Size: 3}, func(u string) (*http.Request, error) {
+			req, err := http.NewRequest("GET", u, nil)
+			if err == nil {
+				req.Header.Set("Range", "bytes=0-9")
+			}
+			return req, err
+		}, http.StatusForbidden, "", nil},
+		{"content disposition", &operation.PresignOptions{ContentDisposition: `attachment; filename="obj.txt"`}, func(u string) (*http.Request, error) {
+			return http.NewRequest("GET", u, nil)
+		}, http.StatusOK, string(data), func(t *testing.T, s *bsttest.Server, resp *http.Response) {
+			if h := resp.Header.Get("Content-Disposition"); h != `attachment; filename="obj.txt"` {
+				t.Errorf("Content-Disposition %q", h)
+			}
+		}},
+		{"put", &operation.PresignOptions{Method: "PUT"}, func(u string) (*http.Request, error) {
+			return http.NewRequest("PUT", u, strings.NewReader("new content"))
+		}, http.StatusOK, "", func(t *testing.T, s *bsttest.Server, resp *http.Response) {
+			if got, _ := s.Object("bucket", "obj"); string(got) != "new content" {
+				t.Errorf("stored %q", got)
+			}
+		}},
+		{"get url used to put", nil, func(u string) (*http.Request, error) {
+			return http.NewRequest("PUT", strings.Replace(u, "/getfile/", "/put/", 1), strings.NewReader("x"))
+		}, http.StatusForbidden, "", nil},
+		{"other key", nil, func(u string) (*http.Request, error) {
+			return http.NewRequest("GET", strings.Replace(u, "/obj?", "/other?", 1), nil)
+		}, http.StatusForbidden, "", nil},
+		{"added parameter", nil, func(u string) (*http.Request, error) {
+			return http.NewRequest("GET", u+"&X-Bst-Range=bytes%3D0-1", nil)
+		}, http.StatusForbidden, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newTestClient(t, map[string]string{"obj": string(data), "other": string(data)}, withCredentials("ak", "sk"))
+			s.SetCredentials("ak", "sk")
+			u, err := c.PresignURL("obj", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := tt.request(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("%d %s", resp.StatusCode, body)
+			}
+			if tt.body != "" && !bytes.Equal(body, []byte(tt.body)) {
+				t.Errorf("body %q, want %q", body, tt.body)
+			}
+			if tt.check != nil {
+				tt.check(t, s, resp)
+			}
+		})
+	}
+}
+
+func TestPresignURLOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		ak   string
+		opts *operation.PresignOptions
+	}{
+		{"range of a put", "ak", &operation.PresignOptions{Method: "PUT", Offset: 1}},
+		{"unsupported method", "ak", &operation.PresignOptions{Method: "DELETE"}},
+		{"expiry too long", "ak", &operation.PresignOptions{Expires: 8 * 24 * time.Hour}},
+		{"expiry too short", "ak", &operation.PresignOptions{Expires: time.Millisecond}},
+		{"negative range", "ak", &operation.PresignOptions{Offset: -1}},
+		{"no credentials", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := newTestClient(t, nil, withCredentials(tt.ak, "sk"))
+			if u, err := c.PresignURL("obj", tt.opts); err == nil {
+				t.Errorf("presigned %s", u)
+			}
+		})
+	}
+}
+
+func TestPresignURLExpires(t *testing.T) {
+	s, c := newTestClient(t, map[string]string{"obj": "data"}, withCredentials("ak", "sk"))
+	s.SetCredentials("ak", "sk")
+	u, err := c.PresignURL("obj", &operation.PresignOptions{Expires: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the date of the url has a resolution of a second
+	time.Sleep(2 * time.Second)
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expired url answered %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,155 @@
+package operation
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is reported to a ProgressListener while data moves. Bytes counts
+// what has been transferred of Total, a part that is retried starts over, so
+// Bytes may go down. Part is the part or range that moved, 0 for transfers
+// sent as a single stream. Throughput is in bytes per second since the
+// operation started.
+type Progress struct {
+	Op         Operation
+	Key        string
+	Bytes      int64
+	Total      int64
+	Part       int
+	Throughput float64
+}
+
+// ProgressListener is called from the goroutines doing the transfer, with
+// parallel transfers it is called concurrently and must be cheap.
+type ProgressListener func(p Progress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that reports the progress of the uploads
+// and downloads it is passed to, it takes precedence over the listener set
+// with SetProgressListener.
+func WithProgress(ctx context.Context, l ProgressListener) context.Context {
+	return context.WithValue(ctx, progressKey{}, l)
+}
+
+func (b *clientBase) SetProgressListener(l ProgressListener) {
+	b.settings.Lock()
+	defer b.settings.Unlock()
+	b.progress = l
+}
+
+// progressTracker sums up the parts of one operation, it travels with the
+// context down to the requests.
+type progressTracker struct {
+	listener ProgressListener
+	op       Operation
+	key      string
+	start    time.Time
+
+	m     sync.Mutex
+	total int64
+	parts map[int]int64
+	bytes int64
+	moved int64
+}
+
+type trackerKey struct{}
+
+// trackProgress attaches a tracker for op to ctx when there is a listener,
+// total is -1 when the size is not known up front.
+func (b *clientBase) trackProgress(ctx context.Context, op Operation, key string, total int64) context.Context {
+	l, _ := ctx.Value(progressKey{}).(ProgressListener)
+	if l == nil {
+		b.settings.RLock()
+		l = b.progress
+		b.settings.RUnlock()
+	}
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, trackerKey{}, &progressTracker{
+		listener: l,
+		op:       op,
+		key:      key,
+		start:    time.Now(),
+		total:    total,
+		parts:    make(map[int]int64),
+	})
+}
+
+func getTracker(ctx context.Context) *progressTracker {
+	t, _ := ctx.Value(trackerKey{}).(*progressTracker)
+	return t
+}
+
+// progressTotal sets the total once a response tells it.
+func progressTotal(ctx context.Context, total int64) {
+	if t := getTracker(ctx); t != nil {
+		t.m.Lock()
+		if t.total < 0 {
+			t.total = total
+		}
+		t.m.Unlock()
+	}
+}
+
+// progressReader counts what is read from r as part, starting at done bytes
+// already transferred by an earlier request for the same part.
+func progressReader(ctx context.Context, r io.Reader, part int, done int64) io.Reader {
+	t := getTracker(ctx)
+	if t == nil {
+		return r
+	}
+	t.set(part, done)
+	return &countingReader{r: r, t: t, part: part}
+}
+
+// progressDone marks a part as transferred without sending it, for parts a
+// resumed upload skips.
+func progressDone(ctx context.Context, part int, size int64) {
+	if t := getTracker(ctx); t != nil {
+		t.set(part, size)
+	}
+}
+
+func (t *progressTracker) set(part int, done int64) {
+	t.m.Lock()
+	t.bytes += done - t.parts[part]
+	t.parts[part] = done
+	t.m.Unlock()
+}
+
+func (t *progressTracker) add(part int, n int64) {
+	t.m.Lock()
+	t.parts[part] += n
+	t.bytes += n
+	t.moved += n
+	p := Progress{
+		Op:    t.op,
+		Key:   t.key,
+		Bytes: t.bytes,
+		Total: t.total,
+		Part:  part,
+	}
+	if elapsed := time.Now().Sub(t.start).Seconds(); elapsed > 0 {
+		p.Throughput = float64(t.moved) / elapsed
+	}
+	t.m.Unlock()
+	t.listener(p)
+}
+
+type countingReader struct {
+	r    io.Reader
+	t    *progressTracker
+	part int
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	if n > 0 {
+		c.t.add(c.part, int64(n))
+	}
+	return
+}
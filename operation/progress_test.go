@@ -0,0 +1,124 @@
+package operation_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// progressRecorder keeps every report and checks they stay within Total.
+type progressRecorder struct {
+	m       sync.Mutex
+	reports []operation.Progress
+	over    bool
+}
+
+func (r *progressRecorder) listen(p operation.Progress) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.reports = append(r.reports, p)
+	if p.Total >= 0 && p.Bytes > p.Total {
+		r.over = true
+	}
+}
+
+func TestProgress(t *testing.T) {
+	const size = 10000
+	tests := []struct {
+		name string
+		op   operation.Operation
+		call func(ctx context.Context, c *operation.Client, file, dir string) error
+	}{
+		{"upload", operation.OpUpload, func(ctx context.Context, c *operation.Client, file, dir string) error {
+			return c.UploadBytesWithContext(ctx, randomBytes(size), "new", true, false)
+		}},
+		{"multipart upload", operation.OpUpload, func(ctx context.Context, c *operation.Client, file, dir string) error {
+			return c.UploadWithContext(ctx, file, "new", true, false)
+		}},
+		{"download", operation.OpDownload, func(ctx context.Context, c *operation.Client, file, dir string) error {
+			_, err := c.DownloadBytesWithContext(ctx, "obj")
+			return err
+		}},
+		{"download file", operation.OpDownload, func(ctx context.Context, c *operation.Client, file, dir string) error {
+			f, err := c.DownloadFileWithContext(ctx, "obj", filepath.Join(dir, "obj"))
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{"parallel download", operation.OpDownload, func(ctx context.Context, c *operation.Client, file, dir string) error {
+			f, err := c.DownloadFileParallelWithContext(ctx, "obj", filepath.Join(dir, "obj"))
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", randomBytes(size))
+			c, err := operation.NewClient(&operation.Config{
+				IoHosts:         []string{s.Host()},
+				Bucket:          "bucket",
+				PartSize:        4096,
+				UpConcurrency:   2,
+				DownConcurrency: 2,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			file, _ := writeTempFile(t, "file", size)
+
+			r := &progressRecorder{}
+			ctx := operation.WithProgress(context.Background(), r.listen)
+			if err = tt.call(ctx, c, file, t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			if len(r.reports) == 0 {
+				t.Fatal("no progress reported")
+			}
+			last := r.reports[len(r.reports)-1]
+			if last.Op != tt.op || last.Bytes != size || last.Total != size {
+				t.Errorf("last report %+v", last)
+			}
+			if r.over {
+				t.Error("progress beyond the total")
+			}
+		})
+	}
+}
+
+// A listener set on the client reports every operation, one passed with the
+// context takes precedence for that call.
+func TestProgressListenerPrecedence(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.PutObject("bucket", "obj", []byte("data"))
+	c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client, call := &progressRecorder{}, &progressRecorder{}
+	c.SetProgressListener(client.listen)
+	if _, err = c.DownloadBytes("obj"); err != nil {
+		t.Fatal(err)
+	}
+	n := len(client.reports)
+	if n == 0 {
+		t.Fatal("no progress reported to the client listener")
+	}
+	if _, err = c.DownloadBytesWithContext(operation.WithProgress(context.Background(), call.listen), "obj"); err != nil {
+		t.Fatal(err)
+	}
+	if len(call.reports) == 0 || len(client.reports) != n {
+		t.Errorf("%d reports to the call listener, %d new to the client one", len(call.reports), len(client.reports)-n)
+	}
+}
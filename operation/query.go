@@ -1,13 +1,16 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,34 +19,29 @@ import (
 	"github.com/kirsle/configdir"
 )
 
-var queryClient = &http.Client{
-	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   500 * time.Millisecond,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	},
-	Timeout: 1 * time.Second,
-}
-
 var (
 	cacheMap         sync.Map
 	cacheUpdaterLock sync.Mutex
 	cachePersisting  uint32 = 0
 	cacheDirectory          = configdir.LocalCache("qiniu", "go-sdk")
+
+	// queryFailures holds, by cache key, until when the uc hosts of a
+	// queryer are not asked again after a failed query.
+	queryFailures sync.Map
 )
 
+// QueryFailureBackoff is how long the io hosts are not queried again after
+// the uc hosts failed to answer, the configured io hosts are used meanwhile.
+var QueryFailureBackoff = 10 * time.Second
+
 type (
 	Queryer struct {
 		ak      string
 		bucket  string
 		ucHosts []string
+		scheme  string
+		client  *http.Client
+		signer  *Signer
 	}
 
 	cache struct {
@@ -70,22 +68,47 @@ func init() {
 }
 
 func NewQueryer(c *Config) *Queryer {
+	scheme, transport := transportSettings(c)
+	return newQueryer(c, scheme, transport, newTimeouts(c))
+}
+
+func newQueryer(c *Config, scheme string, transport http.RoundTripper, timeouts timeouts) *Queryer {
 	queryer := Queryer{
-		ucHosts: c.IoHosts,
+		ak:      c.AccessKey,
+		ucHosts: dupStrings(c.UcHosts),
 		bucket:  c.Bucket,
+		scheme:  scheme,
+		client:  timeouts.httpClient(transport, opQuery),
+		signer:  NewSigner(credentialsFromConfig(c)),
 	}
 	shuffleHosts(queryer.ucHosts)
 	return &queryer
 }
 
 func (queryer *Queryer) QueryIoHosts(https bool) (urls []string) {
-	if cache, err := queryer.query(); err == nil {
+	if cache, err := queryer.query(context.Background(), elog); err == nil && len(cache.CachedHosts.Hosts) > 0 {
 		domains := cache.CachedHosts.Hosts[0].Io.Domains
 		urls = queryer.fromDomainsToUrls(https, domains)
 	}
 	return
 }
 
+// queryIoDomains returns the discovered io hosts without scheme, the way
+// they are configured in Config.IoHosts. Failed queries are logged to log.
+func (queryer *Queryer) queryIoDomains(ctx context.Context, log Ilog) (hosts []string) {
+	if cache, err := queryer.query(ctx, log); err == nil && len(cache.CachedHosts.Hosts) > 0 {
+		domains := cache.CachedHosts.Hosts[0].Io.Domains
+		hosts = make([]string, len(domains))
+		for i, domain := range domains {
+			if idx := strings.Index(domain, "://"); idx >= 0 {
+				domain = domain[idx+3:]
+			}
+			hosts[i] = domain
+		}
+	}
+	return
+}
+
 func (queryer *Queryer) fromDomainsToUrls(https bool, domains []string) (urls []string) {
 	urls = make([]string, len(domains))
 	for i, domain := range domains {
@@ -100,19 +123,31 @@ func (queryer *Queryer) fromDomainsToUrls(https bool, domains []string) (urls []
 	return urls
 }
 
-func (queryer *Queryer) query() (*cache, error) {
+func (queryer *Queryer) query(ctx context.Context, log Ilog) (*cache, error) {
 	var err error
 	c := queryer.getCache()
 	if c == nil {
+		if err = queryer.backingOff(); err != nil {
+			return nil, err
+		}
 		return func() (*cache, error) {
 			var err error
 			cacheUpdaterLock.Lock()
 			defer cacheUpdaterLock.Unlock()
 			c := queryer.getCache()
 			if c == nil {
-				if c, err = queryer.mustQuery(); err != nil {
+				// the query may have failed for another caller while this
+				// one waited for the lock
+				if err = queryer.backingOff(); err != nil {
+					return nil, err
+				}
+				if c, err = queryer.mustQuery(ctx, log); err != nil {
+					if ctx.Err() == nil {
+						queryFailures.Store(queryer.cacheKey(), time.Now().Add(QueryFailureBackoff))
+					}
 					return nil, err
 				} else {
+					queryFailures.Delete(queryer.cacheKey())
 					queryer.setCache(c)
 					saveQueryersCache()
 					return c, nil
@@ -123,18 +158,81 @@ func (queryer *Queryer) query() (*cache, error) {
 		}()
 	} else {
 		if c.CacheExpiredAt.Before(time.Now()) {
-			queryer.asyncRefresh()
+			queryer.asyncRefresh(log)
 		}
 		return c, err
 	}
 }
 
-func (queryer *Queryer) mustQuery() (c *cache, err error) {
+// backingOff fails while the last query of the uc hosts failed less than
+// QueryFailureBackoff ago, so requests fall back to the configured io hosts
+// instead of waiting for uc hosts that are down.
+func (queryer *Queryer) backingOff() error {
+	until, ok := queryFailures.Load(queryer.cacheKey())
+	if ok && time.Now().Before(until.(time.Time)) {
+		return errors.New("uc hosts failed recently, not queried again before " + until.(time.Time).Format(time.RFC3339))
+	}
+	return nil
+}
 
+func (queryer *Queryer) mustQuery(ctx context.Context, log Ilog) (c *cache, err error) {
+	if len(queryer.ucHosts) == 0 {
+		return nil, errors.New("no uc hosts is configured")
+	}
+	for i := 0; i < len(queryer.ucHosts); i++ {
+		index := int(atomic.AddUint32(&curUcHostIndex, 1) - 1)
+		ucHost := queryer.ucHosts[index%len(queryer.ucHosts)]
+		if c, err = queryer.queryUcHost(ctx, ucHost); err == nil {
+			return
+		}
+		log.Info("query io hosts failed", ucHost, err)
+	}
 	return
 }
 
-func (queryer *Queryer) asyncRefresh() {
+func (queryer *Queryer) queryUcHost(ctx context.Context, ucHost string) (*cache, error) {
+	ucHost = hostUrl(queryer.scheme, ucHost)
+	u := fmt.Sprintf("%s/v4/query?ak=%s&bucket=%s", ucHost, url.QueryEscape(queryer.ak), url.QueryEscape(queryer.bucket))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = queryer.signer.Sign(req); err != nil {
+		return nil, err
+	}
+	resp, err := queryer.client.Do(req)
+	if err != nil {
+		failHostName(ucHost)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		failHostName(ucHost)
+		return nil, errors.New(strings.TrimSpace(string(body)))
+	}
+	succeedHostName(ucHost)
+
+	c := &cache{}
+	if err = json.Unmarshal(body, &c.CachedHosts); err != nil {
+		return nil, err
+	}
+	if len(c.CachedHosts.Hosts) == 0 || len(c.CachedHosts.Hosts[0].Io.Domains) == 0 {
+		return nil, errors.New("no io hosts returned by " + ucHost)
+	}
+	ttl := c.CachedHosts.Hosts[0].Ttl
+	if ttl <= 0 {
+		ttl = defaultQueryTtl
+	}
+	c.CacheExpiredAt = time.Now().Add(time.Duration(ttl) * time.Second)
+	return c, nil
+}
+
+func (queryer *Queryer) asyncRefresh(log Ilog) {
 	go func() {
 		var err error
 
@@ -143,7 +241,7 @@ func (queryer *Queryer) asyncRefresh() {
 
 		c := queryer.getCache()
 		if c == nil || c.CacheExpiredAt.Before(time.Now()) {
-			if c, err = queryer.mustQuery(); err == nil {
+			if c, err = queryer.mustQuery(context.Background(), log); err == nil {
 				queryer.setCache(c)
 				saveQueryersCache()
 			}
@@ -163,12 +261,19 @@ func (queryer *Queryer) setCache(c *cache) {
 	cacheMap.Store(queryer.cacheKey(), c)
 }
 
+// cacheKey includes the uc hosts, different clusters may serve buckets of
+// the same name.
 func (queryer *Queryer) cacheKey() string {
-	return fmt.Sprintf("%s:%s", queryer.bucket, queryer.ak)
+	ucHosts := dupStrings(queryer.ucHosts)
+	sort.Strings(ucHosts)
+	return fmt.Sprintf("%s:%s:%s", queryer.bucket, queryer.ak, strings.Join(ucHosts, ","))
 }
 
 var curUcHostIndex uint32 = 0
 
+// defaultQueryTtl is used when the uc host does not send a ttl, in seconds.
+const defaultQueryTtl = 300
+
 func loadQueryersCache() error {
 	cacheFile, err := os.Open(filepath.Join(cacheDirectory, "query-cache.json"))
 
@@ -210,7 +315,7 @@ func saveQueryersCache() error {
 	if !atomic.CompareAndSwapUint32(&cachePersisting, 0, 1) {
 		return nil
 	}
-	defer atomic.StoreUint32(&cachePersisting, 1)
+	defer atomic.StoreUint32(&cachePersisting, 0)
 
 	cacheFile, err := os.OpenFile(filepath.Join(cacheDirectory, "query-cache.json"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
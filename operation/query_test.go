@@ -0,0 +1,151 @@
+package operation_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestIoHostDiscovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// ucHosts picks the uc hosts out of the cluster and a dead host
+		ucHosts func(c bsttest.Cluster, dead string) []string
+		// deadIoHost is configured, the discovered hosts win over it
+		deadIoHost bool
+	}{
+		{"single uc host", func(c bsttest.Cluster, dead string) []string {
+			return []string{c[0].Host()}
+		}, false},
+		{"dead uc host", func(c bsttest.Cluster, dead string) []string {
+			return []string{dead, c[1].Host()}
+		}, false},
+		{"dead io hosts configured", func(c bsttest.Cluster, dead string) []string {
+			return []string{c[2].Host()}
+		}, true},
+	}
+	dead := bsttest.NewServer()
+	dead.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := bsttest.NewCluster(3, "bucket")
+			defer c.Close()
+			cfg := &operation.Config{UcHosts: tt.ucHosts(c, dead.Host()), Bucket: "bucket"}
+			if tt.deadIoHost {
+				cfg.IoHosts = []string{dead.Host()}
+			}
+			p := operation.NewUploader(cfg)
+			defer p.Close()
+			d := operation.NewDownloader(cfg)
+			defer d.Close()
+			for i := 0; i < 6; i++ {
+				if err := p.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := d.DownloadBytes("obj")
+			if err != nil || !bytes.Equal(got, []byte("data")) {
+				t.Fatalf("got %q, %v", got, err)
+			}
+			queries, puts := 0, 0
+			for _, s := range c {
+				queries += s.Count("query")
+				puts += s.Count("put")
+			}
+			if queries != 1 {
+				t.Errorf("%d uc queries, want 1 for the cached hosts", queries)
+			}
+			if puts != 6 {
+				t.Errorf("%d puts reached the cluster", puts)
+			}
+		})
+	}
+}
+
+func TestQueryCacheIsPerCluster(t *testing.T) {
+	a := bsttest.NewServer("bucket")
+	defer a.Close()
+	b := bsttest.NewServer("bucket")
+	defer b.Close()
+	a.PutObject("bucket", "obj", []byte("a"))
+	b.PutObject("bucket", "obj", []byte("b"))
+	for _, s := range []*bsttest.Server{a, b, a} {
+		d := operation.NewDownloader(&operation.Config{UcHosts: []string{s.Host()}, Bucket: "bucket"})
+		got, err := d.DownloadBytes("obj")
+		d.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, _ := s.Object("bucket", "obj"); !bytes.Equal(got, want) {
+			t.Errorf("got %q from the cluster of %s", got, s.Host())
+		}
+	}
+}
+
+// After a failed uc query the configured io hosts are used without asking
+// the uc hosts again until QueryFailureBackoff has passed.
+func TestQueryFailureBacksOff(t *testing.T) {
+	defer func(backoff time.Duration) { operation.QueryFailureBackoff = backoff }(operation.QueryFailureBackoff)
+	operation.QueryFailureBackoff = 200 * time.Millisecond
+	uc := bsttest.NewServer("bucket")
+	defer uc.Close()
+	uc.SetFault(&bsttest.Fault{ErrorRate: 1})
+	io := bsttest.NewServer("bucket")
+	defer io.Close()
+	p := operation.NewUploader(&operation.Config{UcHosts: []string{uc.Host()}, IoHosts: []string{io.Host()}, Bucket: "bucket"})
+	defer p.Close()
+	for i := 0; i < 5; i++ {
+		if err := p.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := uc.Count("query"); n != 1 {
+		t.Errorf("%d uc queries during the backoff, want 1", n)
+	}
+	if n := io.Count("put"); n != 5 {
+		t.Errorf("%d puts reached the configured io host, want 5", n)
+	}
+
+	time.Sleep(operation.QueryFailureBackoff)
+	uc.SetFault(nil)
+	if err := p.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := uc.Count("query"); n != 2 {
+		t.Errorf("%d uc queries after the backoff, want 2", n)
+	}
+	if n := uc.Count("put"); n != 1 {
+		t.Errorf("%d puts reached the discovered io host, want 1", n)
+	}
+}
+
+// A uc query made for a request gives up with the context of the request.
+func TestQueryUsesCallerContext(t *testing.T) {
+	uc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer uc.Close()
+	io := bsttest.NewServer("bucket")
+	defer io.Close()
+	p := operation.NewUploader(&operation.Config{
+		UcHosts:      []string{strings.TrimPrefix(uc.URL, "http://")},
+		IoHosts:      []string{io.Host()},
+		Bucket:       "bucket",
+		OpTimeoutsMs: map[string]int64{"query": 30000},
+	})
+	defer p.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	p.UploadBytesWithContext(ctx, []byte("data"), "obj", true, false)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("upload returned after %v, the uc query outlived the context", d)
+	}
+}
@@ -0,0 +1,188 @@
+package operation
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket over bytes, a rate of 0 lets everything
+// through. The rate may be changed at any time, transfers in flight pick it
+// up with their next read.
+type RateLimiter struct {
+	m      sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows bytesPerSec bytes per second with bursts of up to
+// one second worth of data.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytesPerSec)
+	return l
+}
+
+func (l *RateLimiter) SetRate(bytesPerSec int64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	l.rate = float64(bytesPerSec)
+	l.tokens = l.rate
+	l.last = time.Now()
+}
+
+func (l *RateLimiter) Rate() int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return int64(l.rate)
+}
+
+// WaitN takes n bytes from the bucket, waiting until they are covered. The
+// bucket may go into debt for reads larger than the burst, the next caller
+// pays for it.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.m.Lock()
+	if l.rate <= 0 {
+		l.m.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.m.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var (
+	globalLimiter = NewRateLimiter(0)
+
+	hostLimitersLock sync.Mutex
+	hostLimiters     = make(map[string]*hostRateLimiter)
+	defaultHostRate  int64
+)
+
+type hostRateLimiter struct {
+	*RateLimiter
+	explicit bool
+}
+
+// SetGlobalRateLimit caps the bandwidth of all transfers of the process
+// together, 0 removes the cap.
+func SetGlobalRateLimit(bytesPerSec int64) {
+	globalLimiter.SetRate(bytesPerSec)
+}
+
+// SetHostRateLimit caps the bandwidth used towards one io host by all
+// clients of the process, 0 removes the cap.
+func SetHostRateLimit(host string, bytesPerSec int64) {
+	hostLimitersLock.Lock()
+	defer hostLimitersLock.Unlock()
+	if l, ok := hostLimiters[host]; ok {
+		l.SetRate(bytesPerSec)
+		l.explicit = true
+		return
+	}
+	hostLimiters[host] = &hostRateLimiter{RateLimiter: NewRateLimiter(bytesPerSec), explicit: true}
+}
+
+// SetDefaultHostRateLimit is SetHostRateLimit for every host that has no
+// limit of its own.
+func SetDefaultHostRateLimit(bytesPerSec int64) {
+	hostLimitersLock.Lock()
+	defer hostLimitersLock.Unlock()
+	defaultHostRate = bytesPerSec
+	for _, l := range hostLimiters {
+		if !l.explicit {
+			l.SetRate(bytesPerSec)
+		}
+	}
+}
+
+func hostLimiter(host string) *RateLimiter {
+	hostLimitersLock.Lock()
+	defer hostLimitersLock.Unlock()
+	l, ok := hostLimiters[host]
+	if !ok {
+		l = &hostRateLimiter{RateLimiter: NewRateLimiter(defaultHostRate)}
+		hostLimiters[host] = l
+	}
+	return l.RateLimiter
+}
+
+// SetRateLimit caps the bandwidth of the uploads of this client, 0 removes
+// the cap.
+func (p *Uploader) SetRateLimit(bytesPerSec int64) {
+	p.limiter.SetRate(bytesPerSec)
+}
+
+// SetRateLimit caps the bandwidth of the downloads of this client, 0 removes
+// the cap.
+func (d *Downloader) SetRateLimit(bytesPerSec int64) {
+	d.limiter.SetRate(bytesPerSec)
+}
+
+// maxLimitedRead keeps a single read from taking many seconds worth of
+// tokens at low rates.
+const maxLimitedRead = 32 << 10
+
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters [3]*RateLimiter
+}
+
+// limitReader throttles r by the client, host and global limits, limiter
+// may be nil for clients without a limit of their own.
+func limitReader(ctx context.Context, limiter *RateLimiter, host string, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiters: [3]*RateLimiter{limiter, hostLimiter(host), globalLimiter}}
+}
+
+func (p Uploader) limitReader(ctx context.Context, host string, r io.Reader) io.Reader {
+	return limitReader(ctx, p.limiter, host, r)
+}
+
+func (d *Downloader) limitReader(ctx context.Context, host string, r io.Reader) io.Reader {
+	return limitReader(ctx, d.limiter, host, r)
+}
+
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	if len(p) > maxLimitedRead {
+		p = p[:maxLimitedRead]
+	}
+	n, err = l.r.Read(p)
+	if n > 0 {
+		for _, limiter := range l.limiters {
+			if werr := limiter.WaitN(l.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,81 @@
+package operation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// With a burst of one second worth of data, moving one and a half times the
+// rate takes at least half a second under a limit.
+func TestRateLimits(t *testing.T) {
+	const (
+		rate = 100000
+		size = rate * 3 / 2
+	)
+	tests := []struct {
+		name    string
+		limit   func(c *operation.Client, host string) func()
+		upload  bool
+		limited bool
+	}{
+		{"upload", func(c *operation.Client, host string) func() {
+			c.SetUploadRateLimit(rate)
+			return func() {}
+		}, true, true},
+		{"download", func(c *operation.Client, host string) func() {
+			c.SetDownloadRateLimit(rate)
+			return func() {}
+		}, false, true},
+		{"download limit leaves uploads alone", func(c *operation.Client, host string) func() {
+			c.SetDownloadRateLimit(rate)
+			return func() {}
+		}, true, false},
+		{"removed limit", func(c *operation.Client, host string) func() {
+			c.SetDownloadRateLimit(rate)
+			c.SetDownloadRateLimit(0)
+			return func() {}
+		}, false, false},
+		{"host", func(c *operation.Client, host string) func() {
+			operation.SetHostRateLimit(host, rate)
+			return func() { operation.SetHostRateLimit(host, 0) }
+		}, false, true},
+		{"global", func(c *operation.Client, host string) func() {
+			operation.SetGlobalRateLimit(rate)
+			return func() { operation.SetGlobalRateLimit(0) }
+		}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			data := randomBytes(size)
+			s.PutObject("bucket", "obj", data)
+			c, err := operation.NewClient(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			defer tt.limit(c, s.Host())()
+
+			start := time.Now()
+			if tt.upload {
+				err = c.UploadBytes(data, "new", true, false)
+			} else {
+				_, err = c.DownloadBytes("obj")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			if tt.limited && elapsed < 400*time.Millisecond {
+				t.Errorf("limited transfer took %v", elapsed)
+			}
+			if !tt.limited && elapsed > 300*time.Millisecond {
+				t.Errorf("unlimited transfer took %v", elapsed)
+			}
+		})
+	}
+}
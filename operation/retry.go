@@ -0,0 +1,155 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy decides how often and how fast a failed request is retried.
+// Retryable may be replaced to change which errors are retried, it defaults
+// to DefaultRetryable.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Jitter is the fraction of every delay that is randomized, 0 disables
+	// it and 1 picks a delay anywhere between 0 and the full backoff.
+	Jitter    float64
+	Retryable func(op Operation, err error) bool
+}
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = 100 * time.Millisecond
+	defaultMaxDelay    = 5 * time.Second
+	defaultJitter      = 0.2
+)
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: defaultMaxAttempts,
+		BaseDelay:   defaultBaseDelay,
+		MaxDelay:    defaultMaxDelay,
+		Jitter:      defaultJitter,
+		Retryable:   DefaultRetryable,
+	}
+}
+
+// NewRetryPolicy builds the policy described by c, unset fields keep their
+// defaults.
+func NewRetryPolicy(c *Config) *RetryPolicy {
+	r := DefaultRetryPolicy()
+	if c.Retry > 0 {
+		r.MaxAttempts = c.Retry
+	}
+	if c.RetryBaseDelayMs > 0 {
+		r.BaseDelay = time.Duration(c.RetryBaseDelayMs) * time.Millisecond
+	}
+	if c.RetryMaxDelayMs > 0 {
+		r.MaxDelay = time.Duration(c.RetryMaxDelayMs) * time.Millisecond
+	}
+	return r
+}
+
+// Idempotent reports whether repeating op after an unknown outcome is
+// harmless. Non idempotent operations are only retried when the request
+// never reached the server.
+func (op Operation) Idempotent() bool {
+	switch op {
+	case OpRename, OpInitMultipart, OpCompleteMultipart, OpMakeBucket:
+		return false
+	}
+	return true
+}
+
+// DefaultRetryable retries transient failures: unreachable hosts, 5xx,
+// 408 and 429 responses, broken connections and data corrupted on the way
+// (ErrChecksumMismatch). Client errors such as ErrNotFound or
+// ErrAlreadyExists and canceled contexts are final.
+func DefaultRetryable(op Operation, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return op.Idempotent()
+	}
+	if e.Kind == ErrChecksumMismatch {
+		return op.Idempotent()
+	}
+	if e.StatusCode != 0 {
+		switch {
+		case e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests:
+			return true
+		case e.StatusCode >= http.StatusInternalServerError:
+			return op.Idempotent()
+		}
+		return false
+	}
+	if op.Idempotent() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(e.Err, &opErr) && opErr.Op == "dial"
+}
+
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	d := r.BaseDelay
+	for i := 0; i < attempt && d < r.MaxDelay; i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	if r.Jitter > 0 && d > 0 {
+		randomLock.Lock()
+		f := random.Float64()
+		randomLock.Unlock()
+		d -= time.Duration(float64(d) * r.Jitter * f)
+	}
+	return d
+}
+
+// do calls fn until it succeeds, the policy gives up or ctx is done. The
+// retries are logged to log, the logger of the calling client.
+func (r *RetryPolicy) do(ctx context.Context, log Ilog, op Operation, fn func() error) error {
+	return r.doAtLeast(ctx, log, op, 0, fn)
+}
+
+// failover is do for a fn that moves to another of hosts on every attempt,
+// it makes at least one attempt per host whatever MaxAttempts is. Only the
+// backoff and the retryable errors come from the policy then.
+func (r *RetryPolicy) failover(ctx context.Context, log Ilog, op Operation, hosts int, fn func() error) error {
+	return r.doAtLeast(ctx, log, op, hosts, fn)
+}
+
+func (r *RetryPolicy) doAtLeast(ctx context.Context, log Ilog, op Operation, minAttempts int, fn func() error) (err error) {
+	if r == nil {
+		r = DefaultRetryPolicy()
+	}
+	retryable := r.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+	maxAttempts := r.MaxAttempts
+	if maxAttempts < minAttempts {
+		maxAttempts = minAttempts
+	}
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || attempt+1 >= maxAttempts || ctx.Err() != nil || !retryable(op, err) {
+			return
+		}
+		log.Info("retry", op, attempt+1, err)
+		t := time.NewTimer(r.backoff(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
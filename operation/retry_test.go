@@ -0,0 +1,207 @@
+package operation_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		fault    *bsttest.Fault
+		call     func(d *operation.Downloader, m *operation.Modify) error
+		action   string
+		attempts int
+	}{
+		{"server errors are retried", &bsttest.Fault{ErrorRate: 1}, func(d *operation.Downloader, m *operation.Modify) error {
+			_, err := d.DownloadBytes("obj")
+			return err
+		}, "getfile", 4},
+		{"dropped connections are retried", &bsttest.Fault{DropRate: 1}, func(d *operation.Downloader, m *operation.Modify) error {
+			return m.DeleteFile("obj")
+		}, "deletefile", 4},
+		{"too many requests are retried", &bsttest.Fault{ErrorRate: 1, StatusCode: 429}, func(d *operation.Downloader, m *operation.Modify) error {
+			_, err := m.MetaInfo("obj")
+			return err
+		}, "metadetail", 4},
+		{"not found is final", nil, func(d *operation.Downloader, m *operation.Modify) error {
+			_, err := d.DownloadBytes("missing")
+			return err
+		}, "getfile", 1},
+		{"rename is not repeated", &bsttest.Fault{ErrorRate: 1}, func(d *operation.Downloader, m *operation.Modify) error {
+			return m.RenameFile("obj", "new")
+		}, "rename", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", []byte("data"))
+			s.SetFault(tt.fault)
+			cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 4, RetryBaseDelayMs: 1}
+			d := operation.NewDownloader(cfg)
+			defer d.Close()
+			m := operation.NewModifier(cfg)
+			defer m.Close()
+			if err := tt.call(d, m); err == nil {
+				t.Fatal("call succeeded")
+			}
+			if n := s.Count(tt.action); n != tt.attempts {
+				t.Errorf("%s requests = %d, want %d", tt.action, n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetFault(&bsttest.Fault{ErrorRate: 1})
+	d := operation.NewDownloader(&operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket"})
+	defer d.Close()
+	var retried []error
+	d.SetRetryPolicy(&operation.RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   20 * time.Millisecond,
+		MaxDelay:    40 * time.Millisecond,
+		Retryable: func(op operation.Operation, err error) bool {
+			retried = append(retried, err)
+			return operation.DefaultRetryable(op, err)
+		},
+	})
+	start := time.Now()
+	_, err := d.DownloadBytes("obj")
+	if !errors.Is(err, operation.ErrHostUnavailable) {
+		t.Fatalf("err = %v", err)
+	}
+	// 20ms, 40ms and 40ms capped by MaxDelay
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+	if len(retried) != 3 || s.Count("getfile") != 4 {
+		t.Errorf("%d retry decisions for %d requests", len(retried), s.Count("getfile"))
+	}
+}
+
+// A range moves to another host on every attempt, it must try all of them
+// even when the policy allows fewer attempts.
+func TestRangeFailoverTriesEveryHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		download func(d *operation.Downloader) ([]byte, error)
+		config   func(c *operation.Config)
+	}{
+		{"parallel download", func(d *operation.Downloader) ([]byte, error) {
+			f, err := d.DownloadFileParallel("obj", filepath.Join(t.TempDir(), "obj"))
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			buf := new(bytes.Buffer)
+			_, err = buf.ReadFrom(f)
+			return buf.Bytes(), err
+		}, func(c *operation.Config) { c.PartSize = 1000 }},
+		{"block cache", func(d *operation.Downloader) ([]byte, error) {
+			_, data, err := d.DownloadRangeBytes("obj", 0, 4000)
+			return data, err
+		}, func(c *operation.Config) {
+			c.BlockCacheSize = 1 << 20
+			c.BlockSize = 1000
+			c.ReadAhead = -1
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := bsttest.NewCluster(5, "bucket")
+			defer c.Close()
+			data := randomBytes(4000)
+			c[0].PutObject("bucket", "obj", data)
+			for _, s := range c[:4] {
+				s.SetFault(&bsttest.Fault{ErrorRate: 1, Match: isAction("getfile")})
+			}
+			cfg := &operation.Config{IoHosts: c.Hosts(), Bucket: "bucket", Retry: 1, RetryBaseDelayMs: 1}
+			tt.config(cfg)
+			d := operation.NewDownloader(cfg)
+			defer d.Close()
+			got, err := tt.download(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+// recordLogger keeps the messages logged at info level and above.
+type recordLogger struct {
+	m    sync.Mutex
+	msgs []string
+}
+
+func (l *recordLogger) record(v ...interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprint(v...))
+}
+
+func (l *recordLogger) count(prefix string) int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	n := 0
+	for _, msg := range l.msgs {
+		if strings.HasPrefix(msg, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *recordLogger) Debug(v ...interface{})                {}
+func (l *recordLogger) Info(v ...interface{})                 { l.record(v...) }
+func (l *recordLogger) Infof(format string, v ...interface{}) { l.record(fmt.Sprintf(format, v...)) }
+func (l *recordLogger) Warn(v ...interface{})                 { l.record(v...) }
+func (l *recordLogger) Error(v ...interface{})                { l.record(v...) }
+func (l *recordLogger) Fatal(v ...interface{})                { l.record(v...) }
+
+// Retries and failed uc queries go to the logger of the client, not only to
+// the one of the package.
+func TestRetryLogsToClientLogger(t *testing.T) {
+	s := bsttest.NewServer("bucket")
+	defer s.Close()
+	s.SetFault(&bsttest.Fault{ErrorRate: 1})
+	uc := bsttest.NewServer()
+	uc.Close()
+	c, err := operation.NewClient(&operation.Config{
+		IoHosts:          []string{s.Host()},
+		UcHosts:          []string{uc.Host()},
+		Bucket:           "bucket",
+		Retry:            3,
+		RetryBaseDelayMs: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	l := &recordLogger{}
+	c.SetLogger(l)
+	if _, err = c.DownloadBytes("obj"); err == nil {
+		t.Fatal("download through a failing host succeeded")
+	}
+	if n := l.count("retry"); n != 2 {
+		t.Errorf("%d retries logged, want 2", n)
+	}
+	if n := l.count("query io hosts failed"); n == 0 {
+		t.Error("failed uc query not logged")
+	}
+}
@@ -0,0 +1,95 @@
+package operation
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// schemeOf is the scheme of the requests to hosts configured without one,
+// http unless Config.Scheme says otherwise.
+func schemeOf(c *Config) (string, error) {
+	switch scheme := strings.ToLower(c.Scheme); scheme {
+	case "":
+		return SchemeHTTP, nil
+	case SchemeHTTP, SchemeHTTPS:
+		return scheme, nil
+	default:
+		return "", fmt.Errorf("unknown scheme %q", c.Scheme)
+	}
+}
+
+// newTLSConfig builds the TLS settings of c, nil when it has none and the
+// defaults of net/http apply. CaFile replaces the system roots with the PEM
+// bundle, CertFile and KeyFile are the client certificate for mTLS and
+// ServerName is verified instead of the host name.
+func newTLSConfig(c *Config) (*tls.Config, error) {
+	if c.CaFile == "" && c.CertFile == "" && c.KeyFile == "" && c.ServerName == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{ServerName: c.ServerName}
+	if c.CaFile != "" {
+		pem, err := ioutil.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.CaFile)
+		}
+		conf.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("cert_file and key_file go together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// hostUrl prefixes host with the scheme, hosts configured with a scheme
+// keep theirs.
+func hostUrl(scheme, host string) string {
+	if strings.Contains(host, "://") {
+		return host
+	}
+	return scheme + "://" + host
+}
+
+func (b *clientBase) hostUrl(host string) string {
+	return hostUrl(b.scheme, host)
+}
+
+// probeWith is defaultProbe over scheme and client.
+func probeWith(scheme string, client *http.Client) func(ctx context.Context, host string) error {
+	return func(ctx context.Context, host string) error {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", hostUrl(scheme, host)+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return responseError("healthcheck", host, "", "", resp, nil)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+package operation_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+// writePem writes the PEM block of typ and der to dir/name.
+func writePem(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// newClientCert makes a self signed client certificate and writes it with
+// its key to dir.
+func newClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, writePem(t, dir, "client.pem", "CERTIFICATE", der), writePem(t, dir, "client.key", "EC PRIVATE KEY", keyDer)
+}
+
+func TestTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		mtls       bool
+		withScheme bool
+		config     func(c *operation.Config, ca, cert, key string)
+		ok         bool
+	}{
+		{"ca file", false, false, func(c *operation.Config, ca, cert, key string) {
+			c.CaFile = ca
+		}, true},
+		{"unknown authority", false, false, func(c *operation.Config, ca, cert, key string) {}, false},
+		{"server name", false, false, func(c *operation.Config, ca, cert, key string) {
+			c.CaFile, c.ServerName = ca, "example.com"
+		}, true},
+		{"wrong server name", false, false, func(c *operation.Config, ca, cert, key string) {
+			c.CaFile, c.ServerName = ca, "other.org"
+		}, false},
+		{"scheme in the host", false, true, func(c *operation.Config, ca, cert, key string) {
+			c.Scheme, c.CaFile = "", ca
+		}, true},
+		{"client certificate", true, false, func(c *operation.Config, ca, cert, key string) {
+			c.CaFile, c.CertFile, c.KeyFile = ca, cert, key
+		}, true},
+		{"missing client certificate", true, false, func(c *operation.Config, ca, cert, key string) {
+			c.CaFile = ca
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", []byte("data"))
+			ts := httptest.NewUnstartedServer(s)
+			dir := t.TempDir()
+			clientCert, cert, key := newClientCert(t, dir)
+			if tt.mtls {
+				pool := x509.NewCertPool()
+				pool.AddCert(clientCert)
+				ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+			}
+			ts.StartTLS()
+			defer ts.Close()
+			ca := writePem(t, dir, "ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+
+			host := strings.TrimPrefix(ts.URL, "https://")
+			if tt.withScheme {
+				host = ts.URL
+			}
+			cfg := &operation.Config{IoHosts: []string{host}, Bucket: "bucket", Scheme: operation.SchemeHTTPS, Retry: 1}
+			tt.config(cfg, ca, cert, key)
+			c, err := operation.NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			got, err := c.DownloadBytes("obj")
+			if !tt.ok {
+				if !errors.Is(err, operation.ErrHostUnavailable) {
+					t.Fatalf("err = %v, want a failed handshake", err)
+				}
+				return
+			}
+			if err != nil || string(got) != "data" {
+				t.Fatalf("%q, %v", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package operation
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// opQuery is the uc query and the health check probe, it only names their
+// timeout in Config.OpTimeoutsMs.
+const opQuery Operation = "query"
+
+const (
+	defaultTimeout         = 10 * time.Minute
+	defaultQueryTimeout    = time.Second
+	defaultDialTimeout     = time.Second
+	defaultKeepAlive       = 30 * time.Second
+	defaultMaxIdleConns    = 100
+	defaultIdleConnTimeout = 90 * time.Second
+	// proxyDirect as Config.Proxy turns off the proxy of the environment.
+	proxyDirect = "direct"
+)
+
+func millisOr(ms int64, fallback time.Duration) time.Duration {
+	if ms != 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return fallback
+}
+
+// newTransport builds the connection pool of c, or returns Config.Transport
+// as is when it is set, the TLS and dial settings of c do not apply to it
+// then. Every client gets its own, shared by its uploads, downloads, modify
+// and bucket requests, uc queries and health checks.
+func newTransport(c *Config, tlsConf *tls.Config) (http.RoundTripper, error) {
+	if c.Transport != nil {
+		return c.Transport, nil
+	}
+	dialer := &net.Dialer{
+		Timeout:   millisOr(c.DialTimeoutMs, defaultDialTimeout),
+		KeepAlive: millisOr(c.KeepAliveMs, defaultKeepAlive),
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          defaultMaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       millisOr(c.IdleConnTimeoutMs, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     c.DisableKeepAlives,
+		ForceAttemptHTTP2:     c.Http2,
+		TLSClientConfig:       tlsConf,
+	}
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+	}
+	// every host is reached through the socket, the host name only goes
+	// into the Host header and the TLS handshake
+	if c.UnixSocket != "" {
+		socket := c.UnixSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		return transport, nil
+	}
+	switch c.Proxy {
+	case "":
+		transport.Proxy = http.ProxyFromEnvironment
+	case proxyDirect:
+	default:
+		u, err := url.Parse(c.Proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return transport, nil
+}
+
+// checkTransport reports the errors in the scheme, TLS and connection
+// settings of c.
+func checkTransport(c *Config) error {
+	if _, err := schemeOf(c); err != nil {
+		return err
+	}
+	conf, err := newTLSConfig(c)
+	if err != nil {
+		return err
+	}
+	_, err = newTransport(c, conf)
+	return err
+}
+
+// transportSettings is the scheme and transport of c for the constructors
+// that cannot fail, errors are logged and the defaults used. NewClient
+// reports them instead.
+func transportSettings(c *Config) (string, http.RoundTripper) {
+	scheme, err := schemeOf(c)
+	if err != nil {
+		elog.Error("invalid scheme, using http", err)
+		scheme = SchemeHTTP
+	}
+	conf, err := newTLSConfig(c)
+	if err != nil {
+		elog.Error("invalid tls settings, using the defaults", err)
+	}
+	transport, err := newTransport(c, conf)
+	if err != nil {
+		elog.Error("invalid transport settings, using the proxy of the environment", err)
+		fallback := *c
+		fallback.Proxy = ""
+		transport, _ = newTransport(&fallback, conf)
+	}
+	return scheme, transport
+}
+
+// timeouts bound every request from the dial to the end of the body, per
+// operation in Config.OpTimeoutsMs, BaseTimeoutMs for the others. The uc
+// query and the health check probe default to a second.
+type timeouts struct {
+	base time.Duration
+	ops  map[Operation]time.Duration
+}
+
+func newTimeouts(c *Config) timeouts {
+	t := timeouts{base: millisOr(c.BaseTimeoutMs, defaultTimeout)}
+	if len(c.OpTimeoutsMs) > 0 {
+		t.ops = make(map[Operation]time.Duration, len(c.OpTimeoutsMs))
+		for op, ms := range c.OpTimeoutsMs {
+			t.ops[Operation(op)] = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return t
+}
+
+func (t timeouts) of(op Operation) time.Duration {
+	if d, ok := t.ops[op]; ok {
+		return d
+	}
+	if op == opQuery {
+		return defaultQueryTimeout
+	}
+	return t.base
+}
+
+// httpClient is the client of the requests of op, they all share transport.
+func (t timeouts) httpClient(transport http.RoundTripper, op Operation) *http.Client {
+	return &http.Client{Transport: transport, Timeout: t.of(op)}
+}
+
+// timeoutError is a request that ran out of the timeout of its operation.
+// The http.Client reports it as context.DeadlineExceeded like a deadline of
+// the caller, but unlike that one it is a failure of the host and retried,
+// so the wrapped error is not unwrapped.
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	return e.err.Error()
+}
+
+func (e *timeoutError) Timeout() bool {
+	return true
+}
+
+// requestTimeout hides the context.DeadlineExceeded of err when the context
+// of req is still alive, so the timeout of the operation expired.
+func requestTimeout(req *http.Request, err error) error {
+	if req.Context().Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &timeoutError{err: err}
+}
+
+// timeoutBody applies requestTimeout to the reads of a response body, the
+// timeout of the operation runs until the body is read.
+type timeoutBody struct {
+	io.ReadCloser
+	req *http.Request
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = requestTimeout(b.req, err)
+	}
+	return n, err
+}
@@ -0,0 +1,160 @@
+package operation_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mostcute/bst-go-sdk/operation"
+	"github.com/mostcute/bst-go-sdk/operation/bsttest"
+)
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       int64
+		ops        map[string]int64
+		downloadOk bool
+		metaOk     bool
+	}{
+		{"defaults", 0, nil, true, true},
+		{"base", 20, nil, false, false},
+		{"operation", 0, map[string]int64{"download": 20}, false, true},
+		{"operation over base", 20, map[string]int64{"download": 1000}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			s.PutObject("bucket", "obj", []byte("data"))
+			s.SetFault(&bsttest.Fault{Latency: 60 * time.Millisecond})
+			c, err := operation.NewClient(&operation.Config{
+				IoHosts:       []string{s.Host()},
+				Bucket:        "bucket",
+				Retry:         1,
+				BaseTimeoutMs: tt.base,
+				OpTimeoutsMs:  tt.ops,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			_, err = c.DownloadBytes("obj")
+			if (err == nil) != tt.downloadOk || err != nil && !errors.Is(err, operation.ErrHostUnavailable) {
+				t.Errorf("download: %v", err)
+			}
+			_, err = c.MetaInfo("obj")
+			if (err == nil) != tt.metaOk || err != nil && !errors.Is(err, operation.ErrHostUnavailable) {
+				t.Errorf("meta info: %v", err)
+			}
+		})
+	}
+}
+
+// The timeout of an operation is a failure of the host and tried on the
+// next one, the deadline of the caller ends the operation.
+func TestTimeoutOrDeadline(t *testing.T) {
+	c := bsttest.NewCluster(2, "bucket")
+	defer c.Close()
+	c[0].PutObject("bucket", "obj", []byte("data"))
+	c[0].SetFault(&bsttest.Fault{Latency: 60 * time.Millisecond})
+	d := operation.NewDownloader(&operation.Config{IoHosts: c.Hosts(), Bucket: "bucket", BaseTimeoutMs: 30, RetryBaseDelayMs: 1})
+	defer d.Close()
+	if _, err := d.DownloadBytes("obj"); err != nil {
+		t.Fatalf("timed out download not tried on the next host: %v", err)
+	}
+
+	c[1].SetFault(&bsttest.Fault{Latency: 60 * time.Millisecond})
+	d = operation.NewDownloader(&operation.Config{IoHosts: c.Hosts(), Bucket: "bucket", RetryBaseDelayMs: 1})
+	defer d.Close()
+	before := c[0].Count("getfile") + c[1].Count("getfile")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := d.DownloadBytesWithContext(ctx, "obj"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := c[0].Count("getfile") + c[1].Count("getfile") - before; n != 1 {
+		t.Errorf("%d attempts after the deadline of the caller", n)
+	}
+}
+
+type countingTransport struct {
+	n int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.n, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Every request of a client goes through its transport, however it is
+// reached.
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *bsttest.Server, c *operation.Config) func() int
+	}{
+		{"custom transport", func(t *testing.T, s *bsttest.Server, c *operation.Config) func() int {
+			transport := &countingTransport{}
+			c.Transport = transport
+			return func() int { return int(atomic.LoadInt32(&transport.n)) }
+		}},
+		{"unix socket", func(t *testing.T, s *bsttest.Server, c *operation.Config) func() int {
+			socket := filepath.Join(t.TempDir(), "bst.sock")
+			l, err := net.Listen("unix", socket)
+			if err != nil {
+				t.Skip("no unix sockets:", err)
+			}
+			var n int32
+			server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&n, 1)
+				s.ServeHTTP(w, r)
+			})}
+			go server.Serve(l)
+			t.Cleanup(func() { server.Close() })
+			c.IoHosts, c.UnixSocket = []string{"bst.invalid"}, socket
+			return func() int { return int(atomic.LoadInt32(&n)) }
+		}},
+		{"proxy", func(t *testing.T, s *bsttest.Server, c *operation.Config) func() int {
+			var n int32
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&n, 1)
+				s.ServeHTTP(w, r)
+			}))
+			t.Cleanup(proxy.Close)
+			c.IoHosts, c.Proxy = []string{"bst.invalid"}, proxy.URL
+			return func() int { return int(atomic.LoadInt32(&n)) }
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bsttest.NewServer("bucket")
+			defer s.Close()
+			cfg := &operation.Config{IoHosts: []string{s.Host()}, Bucket: "bucket", Retry: 1}
+			count := tt.setup(t, s, cfg)
+			c, err := operation.NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if err = c.UploadBytes([]byte("data"), "obj", true, false); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := c.DownloadBytes("obj"); err != nil || string(got) != "data" {
+				t.Fatalf("%q, %v", got, err)
+			}
+			if _, err = c.ListBucket(); err != nil {
+				t.Fatal(err)
+			}
+			if n := count(); n != 3 {
+				t.Errorf("%d requests through the transport, want 3", n)
+			}
+		})
+	}
+}
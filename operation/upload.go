@@ -4,96 +4,89 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
-	"errors"
 	"github.com/qiniupd/qiniu-go-sdk/x/log.v7"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"strconv"
-	"sync/atomic"
 	"time"
 )
 
-var curUpHostIndex uint32 = 0
-
 type Uploader struct {
+	*clientBase
+	limiter       *RateLimiter
 	bucket        string
-	upHosts       []string
 	partSize      int64
 	upConcurrency int
 	overview      bool
-	queryer       *Queryer
+	resumable     bool
+	checkpointDir string
+	checksum      string
 }
 
-var uploadClient = &http.Client{
-	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   1 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	},
-	Timeout: 10 * time.Minute,
+func (p *Uploader) Upload(file string, key string, overView bool, byteMode bool) (err error) {
+	return p.UploadWithContext(context.Background(), file, key, overView, byteMode)
 }
 
-func (p *Uploader) Upload(file string, key string, overView bool, byteMode bool) (err error) {
+func (p *Uploader) UploadWithContext(ctx context.Context, file string, key string, overView bool, byteMode bool) (err error) {
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		p.logger().Info("up time ", key, time.Now().Sub(t))
 	}()
-	//key = strings.TrimPrefix(key, "/")
 	f, err := os.Open(file)
 	if err != nil {
-		elog.Info("open file failed: ", file, err)
+		p.logger().Info("open file failed: ", file, err)
 		return err
 	}
 	defer f.Close()
 
 	fInfo, err := f.Stat()
 	if err != nil {
-		elog.Info("get file stat failed: ", err)
+		p.logger().Info("get file stat failed: ", err)
 		return err
 	}
+	ctx = p.trackProgress(ctx, OpUpload, key, fInfo.Size())
 	header := make(map[string]string)
 	header["overwrite"] = strconv.FormatBool(overView)
 	header["blocksize"] = strconv.FormatInt(p.partSize, 10)
 	if byteMode && fInfo.Size() > 32 {
 		log.Info("Bytes Mode")
-		_, err = f.Seek(-32, os.SEEK_END)
-		if err != nil {
-			elog.Fatal(err)
-		}
 		b3 := make([]byte, 32)
-		_, err = io.ReadAtLeast(f, b3, 32)
-		if err != nil {
-			elog.Fatal(err)
+		if _, err = f.ReadAt(b3, fInfo.Size()-32); err != nil {
+			p.logger().Info("read last bytes failed: ", file, err)
+			return err
 		}
-		//header["lastbytes"] = string(b3)
 		header["lastbytes"] = base64.StdEncoding.EncodeToString(b3)
 	}
-	log.Info(header)
-	for i := 0; i < 3; i++ {
-		err = p.put2(context.Background(), nil, key, newReaderAtNopCloser(f), fInfo.Size(), p.bucket, header)
-		if err == nil {
-			break
+	if p.multipart(fInfo.Size()) {
+		if p.resumable {
+			err = p.resumableUpload(ctx, file, key, f, fInfo, header)
+		} else {
+			err = p.multipartUpload(ctx, key, f, fInfo.Size(), header)
+		}
+		if !multipartUnsupported(err) {
+			return err
 		}
-		elog.Info("small upload retry", i, err)
+		p.logger().Info("multipart upload not supported, falling back to a single put", key, err)
 	}
-	return
+	if err = p.setChecksum(header, f, fInfo.Size()); err != nil {
+		return err
+	}
+	return p.retryPolicy().do(ctx, p.logger(), OpUpload, func() error {
+		return p.put2(ctx, nil, key, newReaderAtNopCloser(f), fInfo.Size(), p.bucket, header)
+	})
 }
 func (p *Uploader) UploadFromReader(reader io.Reader, size int64, key string, overView bool, byteMode bool, lastbyte io.Reader) (err error) {
+	return p.UploadFromReaderWithContext(context.Background(), reader, size, key, overView, byteMode, lastbyte)
+}
+
+func (p *Uploader) UploadFromReaderWithContext(ctx context.Context, reader io.Reader, size int64, key string, overView bool, byteMode bool, lastbyte io.Reader) (err error) {
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		p.logger().Info("up time ", key, time.Now().Sub(t))
 	}()
-	//key = strings.TrimPrefix(key, "/")
+	ctx = p.trackProgress(ctx, OpUpload, key, size)
 	header := make(map[string]string)
 	header["overwrite"] = strconv.FormatBool(overView)
 	header["blocksize"] = strconv.FormatInt(p.partSize, 10)
@@ -103,30 +96,25 @@ func (p *Uploader) UploadFromReader(reader io.Reader, size int64, key string, ov
 		lastbyte.Read(p)
 		header["lastbytes"] = base64.StdEncoding.EncodeToString(p)
 	}
-	log.Info(header)
-
-	err = p.put(context.Background(), nil, key, reader, size, p.bucket, header)
+	err = p.put(ctx, nil, key, reader, size, p.bucket, header)
 
 	if err != nil {
 		return err
 	}
-
-	//for i := 0; i < 3; i++ {
-	//	err = p.put(context.Background(), nil, key, reader, size, p.bucket, header)
-	//	if err == nil {
-	//		break
-	//	}
-	//	elog.Info("small upload retry", i, err)
-	//}
 	return nil
 }
 
 func (p *Uploader) UploadBytes(data []byte, key string, overView bool, byteMode bool) (err error) {
+	return p.UploadBytesWithContext(context.Background(), data, key, overView, byteMode)
+}
+
+func (p *Uploader) UploadBytesWithContext(ctx context.Context, data []byte, key string, overView bool, byteMode bool) (err error) {
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		p.logger().Info("up time ", key, time.Now().Sub(t))
 	}()
 
+	ctx = p.trackProgress(ctx, OpUpload, key, int64(len(data)))
 	header := make(map[string]string)
 	header["overwrite"] = strconv.FormatBool(overView)
 	header["blocksize"] = strconv.FormatInt(p.partSize, 10)
@@ -134,113 +122,87 @@ func (p *Uploader) UploadBytes(data []byte, key string, overView bool, byteMode
 		log.Info("Bytes Mode")
 		header["lastbytes"] = base64.StdEncoding.EncodeToString(data[len(data)-32-1 : len(data)-1])
 	}
-
-	for i := 0; i < 3; i++ {
-		err = p.put(context.Background(), nil, key, bytes.NewReader(data), int64(len(data)), p.bucket, header)
-		if err == nil {
-			break
-		}
-		elog.Info("small upload retry", i, err)
+	if err = p.setChecksum(header, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
 	}
-	return
+
+	return p.retryPolicy().do(ctx, p.logger(), OpUpload, func() error {
+		return p.put(ctx, nil, key, bytes.NewReader(data), int64(len(data)), p.bucket, header)
+	})
 }
 
 func (p *Uploader) UploadFromReaderNoByte(reader io.Reader, size int64, key string, overView bool) (err error) {
+	return p.UploadFromReaderNoByteWithContext(context.Background(), reader, size, key, overView)
+}
+
+func (p *Uploader) UploadFromReaderNoByteWithContext(ctx context.Context, reader io.Reader, size int64, key string, overView bool) (err error) {
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		p.logger().Info("up time ", key, time.Now().Sub(t))
 	}()
-	//key = strings.TrimPrefix(key, "/")
+	ctx = p.trackProgress(ctx, OpUpload, key, size)
 	header := make(map[string]string)
 	header["overwrite"] = strconv.FormatBool(overView)
 	header["blocksize"] = strconv.FormatInt(p.partSize, 10)
-
-	log.Info(header)
-
-	err = p.put(context.Background(), nil, key, reader, size, p.bucket, header)
+	err = p.put(ctx, nil, key, reader, size, p.bucket, header)
 
 	if err != nil {
 		return err
 	}
-
-	//for i := 0; i < 3; i++ {
-	//	err = p.put(context.Background(), nil, key, reader, size, p.bucket, header)
-	//	if err == nil {
-	//		break
-	//	}
-	//	elog.Info("small upload retry", i, err)
-	//}
 	return nil
 }
 
 func (p *Uploader) UploadFloder(data []byte, key string, overView bool) (err error) {
+	return p.UploadFloderWithContext(context.Background(), data, key, overView)
+}
+
+func (p *Uploader) UploadFloderWithContext(ctx context.Context, data []byte, key string, overView bool) (err error) {
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		p.logger().Info("up time ", key, time.Now().Sub(t))
 	}()
 
+	ctx = p.trackProgress(ctx, OpUpload, key, int64(len(data)))
 	header := make(map[string]string)
 	header["overwrite"] = strconv.FormatBool(overView)
 	header["blocksize"] = strconv.FormatInt(p.partSize, 10)
 	header["floder"] = key
-
-	for i := 0; i < 3; i++ {
-		err = p.put(context.Background(), nil, key, bytes.NewReader(data), int64(len(data)), p.bucket, header)
-		if err == nil {
-			break
-		}
-		elog.Info("small upload retry", i, err)
+	if err = p.setChecksum(header, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
 	}
-	return
+
+	return p.retryPolicy().do(ctx, p.logger(), OpUpload, func() error {
+		return p.put(ctx, nil, key, bytes.NewReader(data), int64(len(data)), p.bucket, header)
+	})
 }
 
 func NewUploader(c *Config) *Uploader {
-	var queryer *Queryer = nil
-
-	if len(c.IoHosts) > 0 {
-		queryer = NewQueryer(c)
-	}
-
 	return &Uploader{
-		bucket:   c.Bucket,
-		upHosts:  dupStrings(c.IoHosts),
-		partSize: c.PartSize,
-		queryer:  queryer,
-	}
-}
-
-func (p Uploader) chooseUpHost() string {
-	switch len(p.upHosts) {
-	case 0:
-		panic("No Up hosts is configured")
-	case 1:
-		return p.upHosts[0]
-	default:
-		var upHost string
-		for i := 0; i <= len(p.upHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curUpHostIndex, 1) - 1)
-			upHost = p.upHosts[index%len(p.upHosts)]
-			if isHostNameValid(upHost) {
-				break
-			}
-		}
-		return upHost
+		bucket:        c.Bucket,
+		clientBase:    newClientBase(c),
+		limiter:       NewRateLimiter(c.UpRateLimit),
+		partSize:      c.PartSize,
+		upConcurrency: c.UpConcurrency,
+		resumable:     c.Resumable,
+		checkpointDir: c.CheckpointDir,
+		checksum:      c.Checksum,
 	}
 }
 
 func (p Uploader) put(ctx context.Context, ret interface{}, key string, data io.Reader, size int64, bucket string,
 	header map[string]string) error {
 
-	upHost := p.chooseUpHost()
-	url := "http://" + upHost + "/objects/put/" + bucket
+	upHost := p.nextHost(ctx)
+	start := time.Now()
+	url := p.hostUrl(upHost) + "/objects/put/" + bucket
 
 	if key != "" {
 		url += "/" + key
 	}
-	elog.Debug("Put2", url)
-	req, err := http.NewRequest("PUT", url, data)
+	p.logger().Debug("Put2", url)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, progressReader(ctx, p.limitReader(ctx, upHost, data), 0, 0))
 	if err != nil {
-		failHostName(upHost)
+		p.failHost(upHost)
 		return err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
@@ -249,35 +211,36 @@ func (p Uploader) put(ctx context.Context, ret interface{}, key string, data io.
 	}
 
 	req.ContentLength = size
-	resp, err := uploadClient.Do(req)
+	resp, err := p.do(OpUpload, upHost, bucket, key, req)
 	if err != nil {
-		failHostName(upHost)
-		return err
+		p.failHost(upHost)
+		return hostError(OpUpload, upHost, bucket, key, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		failHostName(upHost)
+		p.answered(upHost, start, resp.StatusCode)
 		bodyText, _ := ioutil.ReadAll(resp.Body)
 		log.Info(string(bodyText))
-		return errors.New(resp.Status)
+		return responseError(OpUpload, upHost, bucket, key, resp, bodyText)
 	}
-	succeedHostName(upHost)
+	p.succeedHost(upHost, start)
 	return nil
 }
 
 func (p Uploader) put2(ctx context.Context, ret interface{}, key string, data io.ReaderAt, size int64, bucket string,
 	header map[string]string) error {
 
-	upHost := p.chooseUpHost()
-	url := "http://" + upHost + "/objects/put/" + bucket
+	upHost := p.nextHost(ctx)
+	start := time.Now()
+	url := p.hostUrl(upHost) + "/objects/put/" + bucket
 
 	if key != "" {
 		url += "/" + key
 	}
-	elog.Debug("Put2", url)
-	req, err := http.NewRequest("PUT", url, io.NewSectionReader(data, 0, size))
+	p.logger().Debug("Put2", url)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, progressReader(ctx, p.limitReader(ctx, upHost, io.NewSectionReader(data, 0, size)), 0, 0))
 	if err != nil {
-		failHostName(upHost)
+		p.failHost(upHost)
 		return err
 	}
 	for i, v := range header {
@@ -285,10 +248,10 @@ func (p Uploader) put2(ctx context.Context, ret interface{}, key string, data io
 	}
 
 	req.ContentLength = size
-	resp, err := uploadClient.Do(req)
+	resp, err := p.do(OpUpload, upHost, bucket, key, req)
 	if err != nil {
-		failHostName(upHost)
-		return err
+		p.failHost(upHost)
+		return hostError(OpUpload, upHost, bucket, key, err)
 	}
 
 	defer resp.Body.Close()
@@ -299,10 +262,10 @@ func (p Uploader) put2(ctx context.Context, ret interface{}, key string, data io
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		failHostName(upHost)
-		return errors.New(string(body))
+		p.answered(upHost, start, resp.StatusCode)
+		return responseError(OpUpload, upHost, bucket, key, resp, body)
 	}
-	succeedHostName(upHost)
+	p.succeedHost(upHost, start)
 	return nil
 }
 
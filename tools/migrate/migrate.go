@@ -219,6 +219,7 @@ func runMigrate(ctx *cli.Context) error {
 	if args := ctx.Args(); args.Len() > 0 {
 		return fmt.Errorf("invalid command: %q", args.Get(0))
 	}
+	operation.SetGlobalRateLimit(ctx.Int64("bwlimit"))
 	qnConf, err := qn.Load(ctx.String("qiniu"))
 	if err != nil {
 		log.Error("load config error")
@@ -283,6 +284,7 @@ func runMigrateBst(ctx *cli.Context) error {
 	if args := ctx.Args(); args.Len() > 0 {
 		return fmt.Errorf("invalid command: %q", args.Get(0))
 	}
+	operation.SetGlobalRateLimit(ctx.Int64("bwlimit"))
 
 	bstSrc := ctx.String("bstdst")
 	bstSrcx, err := operation.Load(bstSrc)
@@ -469,6 +471,11 @@ var proveCmd = &cli.Command{
 			Usage: "use bytes mode",
 			Value: false,
 		},
+		&cli.Int64Flag{
+			Name:  "bwlimit",
+			Usage: "bandwidth limit of the whole process in bytes per second, 0 for none",
+			Value: 0,
+		},
 	},
 	Action: runMigrate,
 }
@@ -502,6 +509,11 @@ var migrateBst = &cli.Command{
 			Usage: "use bytes mode",
 			Value: false,
 		},
+		&cli.Int64Flag{
+			Name:  "bwlimit",
+			Usage: "bandwidth limit of the whole process in bytes per second, 0 for none",
+			Value: 0,
+		},
 	},
 	Action: runMigrateBst,
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"git.wutoon.com/lintao/bst-go-sdk/operation"
 	logging "github.com/ipfs/go-log/v2"
@@ -172,7 +173,7 @@ func (t *TestCase) DeleteTest() error {
 	}()
 	err := t.Bucketer.DeleteBucket(t.Config.Bucket)
 	if err != nil {
-		if find := strings.Contains(err.Error(), "Bucket not empty cannot delete"); find {
+		if errors.Is(err, operation.ErrBucketNotEmpty) {
 			log.Info("√√√√√ Bucket存在文件 禁止删除 测试通过 √√√√√")
 		}
 	} else {
@@ -195,7 +196,7 @@ func (t *TestCase) DeleteTest() error {
 	}
 	err = t.Uploader.Upload(fmt.Sprintf("%s/test_%s", testTmpPath, strconv.Itoa(0)), "overwrite_test", false, true)
 	if err != nil {
-		if find := strings.Contains(err.Error(), "obj already exist"); find {
+		if errors.Is(err, operation.ErrAlreadyExists) {
 			log.Info("发现上传失败报错 符合预期 校验成功")
 			log.Info("√√√√√ 非覆盖上传文件测试完成 √√√√√")
 		} else {